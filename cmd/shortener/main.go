@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/grpcserver"
 	"github.com/stlesnik/url_shortener/internal/app/server"
 	"github.com/stlesnik/url_shortener/internal/app/services"
 	"github.com/stlesnik/url_shortener/internal/config"
 	"github.com/stlesnik/url_shortener/internal/logger"
+	"google.golang.org/grpc"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	// конфиг
 	cfg, err := config.New()
@@ -38,12 +49,60 @@ func main() {
 		}
 	}()
 
-	srv := server.New(repo, cfg)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Сервер запущен на %s", cfg.ServerAddress)
-	err = srv.Start()
-	if err != nil {
-		log.Fatalf("Не получилось запустить сервер: %s", err)
-		return
+	// сборщик истекших ссылок
+	go services.NewReaper(repo, cfg.ReaperInterval).Run(ctx)
+
+	service := services.New(repo, cfg)
+	defer service.Close()
+	if cfg.URLPolicyFile != "" {
+		policy, err := services.LoadURLPolicy(cfg.URLPolicyFile)
+		if err != nil {
+			logger.Sugaarz.Errorw("failed to load url policy", "error", err)
+			return
+		}
+		service.SetURLPolicy(policy)
+	}
+	if cfg.BlocklistFile != "" {
+		checker, err := services.NewBlocklistChecker(cfg.BlocklistFile)
+		if err != nil {
+			logger.Sugaarz.Errorw("failed to load blocklist", "error", err)
+			return
+		}
+		go checker.Watch(ctx, cfg.BlocklistReloadInterval)
+		service.SetURLChecker(checker)
+	}
+	srv := server.New(service, cfg)
+
+	go func() {
+		log.Printf("Сервер запущен на %s", cfg.ServerAddress)
+		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Не получилось запустить сервер: %s", err)
+		}
+	}()
+
+	var grpcSrv *grpcserver.Server
+	if cfg.GRPCAddress != "" {
+		grpcSrv = grpcserver.New(service, cfg)
+		go func() {
+			log.Printf("gRPC сервер запущен на %s", cfg.GRPCAddress)
+			if err := grpcSrv.Start(); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				log.Fatalf("Не получилось запустить gRPC сервер: %s", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Sugaarz.Errorw("failed to shutdown server", "error", err)
+	}
+	if grpcSrv != nil {
+		if err := grpcSrv.Shutdown(shutdownCtx); err != nil {
+			logger.Sugaarz.Errorw("failed to shutdown grpc server", "error", err)
+		}
 	}
 }
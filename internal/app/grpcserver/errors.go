@@ -0,0 +1,54 @@
+package grpcserver
+
+import (
+	"errors"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcErrors - то же сопоставление, что и у HTTP-обработчиков, но в кодах gRPC
+var grpcErrors = []struct {
+	err  error
+	code codes.Code
+}{
+	{services.ErrInvalidURL, codes.InvalidArgument},
+	{services.ErrURLNotAllowed, codes.InvalidArgument},
+	{services.ErrInvalidShortURL, codes.InvalidArgument},
+	{services.ErrInvalidTags, codes.InvalidArgument},
+	{services.ErrInvalidTitle, codes.InvalidArgument},
+	{services.ErrInvalidPassthrough, codes.InvalidArgument},
+	{services.ErrInvalidTargets, codes.InvalidArgument},
+	{services.ErrInvalidVariants, codes.InvalidArgument},
+	{services.ErrInvalidListQuery, codes.InvalidArgument},
+	{services.ErrAliasInvalid, codes.InvalidArgument},
+	{services.ErrInvalidPassword, codes.InvalidArgument},
+	{services.ErrInvalidRedirectCode, codes.InvalidArgument},
+	{services.ErrInvalidExpiry, codes.InvalidArgument},
+	{services.ErrAliasReserved, codes.InvalidArgument},
+	{services.ErrForbidden, codes.PermissionDenied},
+	{services.ErrURLBlocked, codes.PermissionDenied},
+	{services.ErrPasswordRequired, codes.Unauthenticated},
+	{services.ErrWrongPassword, codes.Unauthenticated},
+	{services.ErrTooManyAttempts, codes.ResourceExhausted},
+	{repository.ErrURLNotFound, codes.NotFound},
+	// удаленная и истекшая ссылки, как 410 в HTTP, отличаются от неизвестного id
+	{repository.ErrURLDeleted, codes.FailedPrecondition},
+	{services.ErrURLExpired, codes.FailedPrecondition},
+	{services.ErrAliasTaken, codes.AlreadyExists},
+	{services.ErrURLAlreadyShortened, codes.AlreadyExists},
+	{services.ErrDeleteQueueFull, codes.Unavailable},
+}
+
+// toStatus переводит ошибку сервиса в статус gRPC, текст внутренних ошибок наружу не уходит
+func toStatus(err error) error {
+	for _, e := range grpcErrors {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, err.Error())
+		}
+	}
+	logger.Sugaarz.Errorw("internal error", "err", err)
+	return status.Error(codes.Internal, "internal server error")
+}
@@ -0,0 +1,164 @@
+package grpcserver
+
+import (
+	"context"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	pb "github.com/stlesnik/url_shortener/internal/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+type shortenerServer struct {
+	pb.UnimplementedShortenerServer
+	service *services.URLShortenerService
+}
+
+func userIDFromCtx(ctx context.Context) string {
+	userID, _ := middleware.UserIDFromContext(ctx)
+	return userID
+}
+
+func linkParams(opts *pb.LinkOptions) services.LinkParams {
+	if opts == nil {
+		return services.LinkParams{}
+	}
+	p := services.LinkParams{
+		TTLSeconds:     opts.TtlSeconds,
+		Password:       opts.GetPassword(),
+		RedirectCode:   int(opts.GetRedirectCode()),
+		Title:          opts.GetTitle(),
+		Tags:           opts.GetTags(),
+		Passthrough:    opts.GetPassthrough(),
+		StickyVariants: opts.GetStickyVariants(),
+	}
+	for _, rule := range opts.GetTargets() {
+		p.Targets = append(p.Targets, repository.TargetRule{OS: rule.GetOs(), Device: rule.GetDevice(), URL: rule.GetUrl()})
+	}
+	for _, v := range opts.GetVariants() {
+		p.Variants = append(p.Variants, repository.Variant{Name: v.GetName(), URL: v.GetUrl(), Weight: int(v.GetWeight())})
+	}
+	if opts.GetExpiresAt() != nil {
+		expiresAt := opts.GetExpiresAt().AsTime()
+		p.ExpiresAt = &expiresAt
+	}
+	return p
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func (s *shortenerServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if err := s.service.ValidateURL(req.GetUrl()); err != nil {
+		return nil, toStatus(err)
+	}
+	opts, err := s.service.NewShortenOptions(userIDFromCtx(ctx), middleware.IsAnonymous(ctx), linkParams(req.GetOptions()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	var (
+		shortURL string
+		isDouble bool
+	)
+	if req.GetAlias() != "" {
+		shortURL, isDouble, err = s.service.CreateSavePrepareAliasURL(ctx, req.GetUrl(), req.GetAlias(), opts)
+	} else {
+		shortURL, isDouble, err = s.service.CreateShortURL(ctx, req.GetUrl(), opts)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ShortenResponse{
+		ShortUrl:  shortURL,
+		Existing:  isDouble,
+		ExpiresAt: timestamp(opts.ExpiresAt),
+	}, nil
+}
+
+func (s *shortenerServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	userID, anonymous := userIDFromCtx(ctx), middleware.IsAnonymous(ctx)
+	entries := make([]services.BatchEntry, len(req.GetItems()))
+	for i, item := range req.GetItems() {
+		entries[i] = s.service.NewBatchEntry(item.GetOriginalUrl(), userID, anonymous, linkParams(item.GetOptions()))
+	}
+	if err := s.service.SaveBatchShortURL(ctx, entries); err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.ShortenBatchResponse{Results: make([]*pb.BatchResult, 0, len(entries))}
+	for i, entry := range entries {
+		result := &pb.BatchResult{
+			CorrelationId: req.GetItems()[i].GetCorrelationId(),
+			ShortUrl:      entry.ShortURL,
+			Status:        string(entry.Status),
+		}
+		if entry.Err != nil {
+			result.Error = entry.Err.Error()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+// Expand отдает исходный url без редиректа, поэтому переход не засчитывается.
+// short_id может быть и полной короткой ссылкой. Как и HTTP API, ссылки под паролем не раскрывает
+func (s *shortenerServer) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	rec, err := s.service.ExpandShortURL(ctx, req.GetShortId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb.ExpandResponse{
+		ShortUrl:     s.service.PrepareShortURL(rec.ShortURL),
+		OriginalUrl:  rec.OriginalURL,
+		RedirectCode: int32(s.service.RedirectCode(rec)),
+		ExpiresAt:    timestamp(rec.ExpiresAt),
+		Title:        rec.Title,
+		Tags:         rec.Tags,
+	}
+	if !rec.CreatedAt.IsZero() {
+		resp.CreatedAt = timestamppb.New(rec.CreatedAt)
+	}
+	return resp, nil
+}
+
+func (s *shortenerServer) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	urls, next, err := s.service.FindUserURLs(ctx, services.ListParams{
+		UserID: userIDFromCtx(ctx),
+		Tags:   req.GetTags(),
+		Search: req.GetQuery(),
+		Sort:   req.GetSort(),
+		Cursor: req.GetCursor(),
+		Limit:  int(req.GetLimit()),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(urls)), NextCursor: next}
+	for _, rec := range urls {
+		item := &pb.UserURL{
+			ShortUrl:    s.service.PrepareShortURL(rec.ShortURL),
+			OriginalUrl: rec.OriginalURL,
+			ExpiresAt:   timestamp(rec.ExpiresAt),
+			Title:       rec.Title,
+			Tags:        rec.Tags,
+		}
+		if !rec.CreatedAt.IsZero() {
+			item.CreatedAt = timestamppb.New(rec.CreatedAt)
+		}
+		resp.Urls = append(resp.Urls, item)
+	}
+	return resp, nil
+}
+
+func (s *shortenerServer) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	if err := s.service.DeleteUserURLs(userIDFromCtx(ctx), req.GetShortIds()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
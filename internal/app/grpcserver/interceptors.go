@@ -0,0 +1,58 @@
+package grpcserver
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func loggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.Sugaarz.Infow("Got grpc request",
+		"method", info.FullMethod,
+		"duration", time.Since(start),
+		"code", status.Code(err).String(),
+	)
+	return resp, err
+}
+
+type authInterceptor struct {
+	auth *middleware.Authenticator
+}
+
+func newAuthInterceptor(auth *middleware.Authenticator) *authInterceptor {
+	return &authInterceptor{auth: auth}
+}
+
+// intercept повторяет WithAuth: ID пользователя берется из метаданных user_id,
+// а без них или с неверной подписью выдается новый в заголовке ответа
+func (a *authInterceptor) intercept(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	userID, ok := a.userIDFromMetadata(ctx)
+	if !ok {
+		ctx = middleware.WithAnonymous(ctx)
+		userID = uuid.New().String()
+		if err := grpc.SetHeader(ctx, metadata.Pairs(middleware.AuthCookieName, a.auth.Sign(userID))); err != nil {
+			logger.Sugaarz.Errorw("failed to send user id header", "err", err)
+		}
+		logger.Sugaarz.Debugw("issued new user id", "user_id", userID)
+	}
+	return handler(middleware.WithUserID(ctx, userID), req)
+}
+
+func (a *authInterceptor) userIDFromMetadata(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(middleware.AuthCookieName)
+	if len(values) == 0 {
+		return "", false
+	}
+	return a.auth.Verify(values[0])
+}
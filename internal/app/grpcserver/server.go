@@ -0,0 +1,48 @@
+// Package grpcserver отдает то же API, что и HTTP-обработчики, для внутренних сервисов
+package grpcserver
+
+import (
+	"context"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/config"
+	pb "github.com/stlesnik/url_shortener/internal/proto"
+	"google.golang.org/grpc"
+	"net"
+)
+
+type Server struct {
+	addr    string
+	grpcSrv *grpc.Server
+}
+
+func New(service *services.URLShortenerService, cfg *config.Config) *Server {
+	auth := newAuthInterceptor(middleware.NewAuthenticator(cfg.SecretKey))
+	grpcSrv := grpc.NewServer(grpc.ChainUnaryInterceptor(loggingInterceptor, auth.intercept))
+	pb.RegisterShortenerServer(grpcSrv, &shortenerServer{service: service})
+	return &Server{addr: cfg.GRPCAddress, grpcSrv: grpcSrv}
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.grpcSrv.Serve(listener)
+}
+
+// Shutdown дает текущим вызовам завершиться, но не дольше, чем живет ctx
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpcSrv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpcSrv.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,171 @@
+package grpcserver
+
+import (
+	"context"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/config"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	pb "github.com/stlesnik/url_shortener/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) pb.ShortenerClient {
+	cfg := &config.Config{BaseURL: "http://localhost:8000", SecretKey: "secret", ReservedAliases: []string{"api"}}
+	return newServiceClient(t, services.New(repository.NewInMemoryRepository(), cfg), cfg)
+}
+
+func newServiceClient(t *testing.T, service *services.URLShortenerService, cfg *config.Config) pb.ShortenerClient {
+	require.NoError(t, logger.InitLogger("dev"))
+	srv := New(service, cfg)
+
+	listener := bufconn.Listen(1 << 20)
+	go func() {
+		_ = srv.grpcSrv.Serve(listener)
+	}()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		srv.grpcSrv.Stop()
+		service.Close()
+	})
+	return pb.NewShortenerClient(conn)
+}
+
+func TestShortener_Shorten(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		req          *pb.ShortenRequest
+		wantCode     codes.Code
+		wantShortURL string
+		wantExisting bool
+	}{
+		{name: "Created", req: &pb.ShortenRequest{Url: "https://vk.com"}, wantCode: codes.OK, wantShortURL: "http://localhost:8000/ymMooIzfwh4="},
+		{name: "Existing", req: &pb.ShortenRequest{Url: "https://vk.com"}, wantCode: codes.OK, wantShortURL: "http://localhost:8000/ymMooIzfwh4=", wantExisting: true},
+		{name: "Alias", req: &pb.ShortenRequest{Url: "https://ya.ru", Alias: "my-link"}, wantCode: codes.OK, wantShortURL: "http://localhost:8000/my-link"},
+		{name: "Alias taken", req: &pb.ShortenRequest{Url: "https://ok.ru", Alias: "my-link"}, wantCode: codes.AlreadyExists},
+		{name: "Reserved alias", req: &pb.ShortenRequest{Url: "https://ok.ru", Alias: "api"}, wantCode: codes.InvalidArgument},
+		{name: "Invalid url", req: &pb.ShortenRequest{Url: "not a url"}, wantCode: codes.InvalidArgument},
+		{name: "Bad redirect code", req: &pb.ShortenRequest{Url: "https://ok.ru", Options: &pb.LinkOptions{RedirectCode: 303}}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Shorten(ctx, tt.req)
+			require.Equal(t, tt.wantCode, status.Code(err), "err: %v", err)
+			if tt.wantCode != codes.OK {
+				return
+			}
+			assert.Equal(t, tt.wantShortURL, resp.GetShortUrl())
+			assert.Equal(t, tt.wantExisting, resp.GetExisting())
+		})
+	}
+}
+
+func TestShortener_UserFlow(t *testing.T) {
+	client := newTestClient(t)
+
+	// первый вызов без метаданных выдает пользователю подписанный ID
+	var header metadata.MD
+	_, err := client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "https://vk.com"},
+		{CorrelationId: "2", OriginalUrl: "not a url"},
+	}}, grpc.Header(&header))
+	require.NoError(t, err)
+	tokens := header.Get("user_id")
+	require.Len(t, tokens, 1)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "user_id", tokens[0])
+
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, "https://vk.com", list.GetUrls()[0].GetOriginalUrl())
+
+	other, err := client.ListUserURLs(context.Background(), &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, other.GetUrls())
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://ok.ru", Options: &pb.LinkOptions{Title: "OK", Tags: []string{"Social"}}})
+	require.NoError(t, err)
+	tagged, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Tags: []string{"social"}, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, tagged.GetUrls(), 1)
+	assert.Equal(t, "OK", tagged.GetUrls()[0].GetTitle())
+	assert.Equal(t, []string{"social"}, tagged.GetUrls()[0].GetTags())
+	assert.Empty(t, tagged.GetNextCursor())
+	_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Sort: "clicks"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	expanded, err := client.Expand(ctx, &pb.ExpandRequest{ShortId: "ymMooIzfwh4="})
+	require.NoError(t, err)
+	assert.Equal(t, "https://vk.com", expanded.GetOriginalUrl())
+	assert.NotNil(t, expanded.GetCreatedAt())
+
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	protected, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://secret.example", Options: &pb.LinkOptions{Password: "pass"}})
+	require.NoError(t, err)
+	protectedID := strings.TrimPrefix(protected.GetShortUrl(), "http://localhost:8000/")
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortId: protectedID})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// как и HTTP API, Expand не раскрывает ссылку под паролем даже с паролем
+	_, err = client.Expand(ctx, &pb.ExpandRequest{ShortId: protectedID, Password: "pass"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestShortener_ExpandGone(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000", SecretKey: "secret"}
+	repo := repository.NewInMemoryRepository()
+	past := time.Now().Add(-time.Minute)
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "deleted", OriginalURL: "https://ya.ru/", IsDeleted: true})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "expired", OriginalURL: "https://ok.ru/", ExpiresAt: &past})
+	client := newServiceClient(t, services.New(repo, cfg), cfg)
+
+	// удаленная и истекшая ссылки отличаются от неизвестной, как 410 и 404 в HTTP
+	for id, want := range map[string]codes.Code{"deleted": codes.FailedPrecondition, "expired": codes.FailedPrecondition, "missing": codes.NotFound} {
+		_, err := client.Expand(context.Background(), &pb.ExpandRequest{ShortId: id})
+		assert.Equal(t, want, status.Code(err), id)
+	}
+}
+
+// hostChecker помечает все url с хостом host
+type hostChecker string
+
+func (c hostChecker) CheckURL(rawURL string) (string, bool) {
+	return "host is flagged", strings.Contains(rawURL, "://"+string(c)+"/")
+}
+
+func TestShortener_ExpandBlocked(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000", SecretKey: "secret", CheckOnRedirect: true}
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "flagged", OriginalURL: "https://evil.com/login"})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "clean", OriginalURL: "https://ya.ru/"})
+	service := services.New(repo, cfg)
+	service.SetURLChecker(hostChecker("evil.com"))
+	client := newServiceClient(t, service, cfg)
+
+	_, err := client.Expand(context.Background(), &pb.ExpandRequest{ShortId: "flagged"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "evil.com/login")
+	expanded, err := client.Expand(context.Background(), &pb.ExpandRequest{ShortId: "clean"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru/", expanded.GetOriginalUrl())
+}
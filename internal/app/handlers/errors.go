@@ -1,17 +1,83 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
 	"github.com/stlesnik/url_shortener/internal/logger"
 	"net/http"
 )
 
 var (
-	ErrReadingBody = errors.New("error reading body")
-	ErrDidntGetURL = errors.New("error getting url")
-	ErrInvalidURL  = errors.New("invalid url to shorten")
+	ErrReadingBody   = errors.New("error reading body")
+	ErrDecodeBody    = errors.New("failed to decode body")
+	ErrDidntGetURL   = errors.New("error getting url")
+	ErrInvalidURL    = errors.New("invalid url to shorten")
+	ErrUnauthorized  = errors.New("user is not authorized")
+	ErrUnknownFormat = errors.New("unknown format")
 )
 
+const codeInternalError = "internal_error"
+
+// apiErrors сопоставляет ошибки со статусом ответа и стабильным кодом, выигрывает первое совпадение
+var apiErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrReadingBody, http.StatusBadRequest, "invalid_body"},
+	{ErrDecodeBody, http.StatusBadRequest, "invalid_body"},
+	{ErrUnknownFormat, http.StatusBadRequest, "invalid_format"},
+	{ErrDidntGetURL, http.StatusBadRequest, "invalid_url"},
+	{ErrInvalidURL, http.StatusBadRequest, "invalid_url"},
+	{services.ErrInvalidURL, http.StatusBadRequest, "invalid_url"},
+	{services.ErrInvalidShortURL, http.StatusBadRequest, "invalid_short_url"},
+	{services.ErrAliasInvalid, http.StatusBadRequest, "alias_invalid"},
+	{services.ErrInvalidPassword, http.StatusBadRequest, "invalid_password"},
+	{services.ErrInvalidRedirectCode, http.StatusBadRequest, "invalid_redirect_code"},
+	{services.ErrInvalidTags, http.StatusBadRequest, "invalid_tags"},
+	{services.ErrInvalidTitle, http.StatusBadRequest, "invalid_title"},
+	{services.ErrInvalidListQuery, http.StatusBadRequest, "invalid_query"},
+	{services.ErrInvalidPassthrough, http.StatusBadRequest, "invalid_passthrough"},
+	{services.ErrInvalidTargets, http.StatusBadRequest, "invalid_targets"},
+	{services.ErrInvalidVariants, http.StatusBadRequest, "invalid_variants"},
+	{services.ErrInvalidExpiry, http.StatusUnprocessableEntity, "invalid_expiry"},
+	{services.ErrURLTooLong, http.StatusUnprocessableEntity, "url_too_long"},
+	{services.ErrURLNotAbsolute, http.StatusUnprocessableEntity, "url_not_absolute"},
+	{services.ErrURLSchemeNotAllowed, http.StatusUnprocessableEntity, "url_scheme_not_allowed"},
+	{services.ErrURLPrivateAddress, http.StatusUnprocessableEntity, "url_private_address"},
+	{services.ErrURLDomainDenied, http.StatusUnprocessableEntity, "url_domain_denied"},
+	{services.ErrURLDomainNotAllowed, http.StatusUnprocessableEntity, "url_domain_not_allowed"},
+	{services.ErrURLSelfRedirect, http.StatusUnprocessableEntity, "url_self_redirect"},
+	{services.ErrURLMalicious, http.StatusUnprocessableEntity, "url_malicious"},
+	{services.ErrURLNotAllowed, http.StatusUnprocessableEntity, "url_not_allowed"},
+	{services.ErrAliasReserved, http.StatusUnprocessableEntity, "alias_reserved"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{services.ErrPasswordRequired, http.StatusUnauthorized, "password_required"},
+	{services.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{services.ErrURLBlocked, http.StatusForbidden, "url_blocked"},
+	{repository.ErrURLNotFound, http.StatusNotFound, "not_found"},
+	{services.ErrAliasTaken, http.StatusConflict, "alias_taken"},
+	{services.ErrURLAlreadyShortened, http.StatusConflict, "url_already_shortened"},
+	{repository.ErrURLDeleted, http.StatusGone, "url_deleted"},
+	{services.ErrURLExpired, http.StatusGone, "url_expired"},
+	{services.ErrStatsUnavailable, http.StatusInternalServerError, "stats_unavailable"},
+	{services.ErrDeleteQueueFull, http.StatusServiceUnavailable, "delete_queue_full"},
+}
+
+// errorStatus возвращает статус ответа и код ошибки, неизвестные ошибки считаются внутренними
+func errorStatus(err error) (int, string) {
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			return e.status, e.code
+		}
+	}
+	return http.StatusInternalServerError, codeInternalError
+}
+
 func WriteError(w http.ResponseWriter, msg string, code int, trace bool) {
 	if trace {
 		logger.Sugaarz.Errorw(msg, "code", code)
@@ -22,3 +88,34 @@ func WriteError(w http.ResponseWriter, msg string, code int, trace bool) {
 	w.WriteHeader(code)
 	_, _ = w.Write([]byte(msg))
 }
+
+// writeTextError - вариант для роутов вне /api/*, которые отвечают простым текстом
+func writeTextError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	WriteError(w, err.Error(), status, code == codeInternalError)
+}
+
+// writeAPIError отдает ошибку в едином конверте /api/*, текст внутренних ошибок наружу не уходит
+func writeAPIError(w http.ResponseWriter, req *http.Request, err error) {
+	status, apiErr := newAPIError(req, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiErr)
+}
+
+func newAPIError(req *http.Request, err error) (int, models.APIError) {
+	status, code := errorStatus(err)
+	requestID := middleware.RequestIDFromContext(req.Context())
+	msg := err.Error()
+	if code == codeInternalError {
+		logger.Sugaarz.Errorw("internal error", "err", err, "code", status, "request_id", requestID)
+		msg = "internal server error"
+	} else {
+		logger.Sugaarz.Infow(msg, "code", status, "reason", code, "request_id", requestID)
+	}
+	return status, models.APIError{Error: models.APIErrorBody{
+		Code:      code,
+		Message:   msg,
+		RequestID: requestID,
+	}}
+}
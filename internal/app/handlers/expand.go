@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net/http"
+)
+
+// expandBatchLimit - каждый id это отдельный запрос к хранилищу
+const expandBatchLimit = 1000
+
+// expandFound - статус найденной ссылки в пакете, у остальных статус совпадает с кодом ошибки
+const expandFound = "found"
+
+func (h *Handler) expandedURL(rec repository.URLRecord) models.APIResponseExpandURL {
+	return models.APIResponseExpandURL{
+		ShortURL:     h.service.PrepareShortURL(rec.ShortURL),
+		OriginalURL:  rec.OriginalURL,
+		RedirectCode: h.service.RedirectCode(rec),
+		Title:        rec.Title,
+		Tags:         rec.Tags,
+		CreatedAt:    rec.CreatedAt,
+		ExpiresAt:    rec.ExpiresAt,
+	}
+}
+
+// ExpandShortURL отдает исходный url по id или короткой ссылке без редиректа и без учета перехода
+func (h *Handler) ExpandShortURL(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got ExpandShortURL request")
+	rec, err := h.service.ExpandShortURL(req.Context(), req.URL.Query().Get("short"))
+	if err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(h.expandedURL(rec)); err != nil {
+		logger.Sugaarz.Errorw("error encoding body", "err", err)
+		return
+	}
+	logger.Sugaarz.Debugw("sent ExpandShortURL response")
+}
+
+// ExpandBatch раскрывает несколько ссылок, ошибка одной из них не мешает остальным
+func (h *Handler) ExpandBatch(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got ExpandBatch request")
+	var shorts []string
+	if err := json.NewDecoder(req.Body).Decode(&shorts); err != nil {
+		writeAPIError(res, req, fmt.Errorf("%w: %v", ErrDecodeBody, err))
+		return
+	}
+	if len(shorts) > expandBatchLimit {
+		writeAPIError(res, req, fmt.Errorf("%w: at most %d short urls per request", ErrDecodeBody, expandBatchLimit))
+		return
+	}
+
+	apiResp := make([]models.APIResponseExpandBatchItem, 0, len(shorts))
+	for _, short := range shorts {
+		item := models.APIResponseExpandBatchItem{Short: short, Status: expandFound}
+		rec, err := h.service.ExpandShortURL(req.Context(), short)
+		if err != nil {
+			_, code := errorStatus(err)
+			if code == codeInternalError {
+				// хранилище недоступно - остальные элементы тоже не раскрыть
+				writeAPIError(res, req, fmt.Errorf("error while expanding batch: %w", err))
+				return
+			}
+			item.Status, item.Error = code, err.Error()
+		} else {
+			item.APIResponseExpandURL = h.expandedURL(rec)
+		}
+		apiResp = append(apiResp, item)
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(apiResp); err != nil {
+		logger.Sugaarz.Errorw("error encoding body", "err", err)
+		return
+	}
+	logger.Sugaarz.Debugw("sent ExpandBatch response", "items", len(apiResp))
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+
+	// exportPageSize - сколько ссылок читать из хранилища за раз, после каждой страницы выгрузка
+	// выталкивается клиенту, и в памяти не бывает больше одной страницы
+	exportPageSize = 500
+
+	// csvFormulaPrefixes - с этих символов табличные редакторы начинают формулу
+	csvFormulaPrefixes = "=+-@\t\r"
+)
+
+// exportCSVHeader - первые колонки совпадают с форматом импорта, файл можно загрузить обратно
+var exportCSVHeader = []string{"original_url", "alias", "expires_at", "tags", "title", "short_url", "created_at"}
+
+func (h *Handler) exportURL(rec repository.URLRecord) models.APIResponseExportURL {
+	item := models.APIResponseExportURL{
+		ShortURL:    h.service.PrepareShortURL(rec.ShortURL),
+		OriginalURL: rec.OriginalURL,
+		Title:       rec.Title,
+		Tags:        rec.Tags,
+		CreatedAt:   rec.CreatedAt,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+	// хеш при повторном импорте получится тот же, поэтому выгружаем только пользовательские id
+	if services.IsAlias(rec.ShortURL) {
+		item.Alias = rec.ShortURL
+	}
+	return item
+}
+
+// csvSafe не дает табличному редактору выполнить значение из ссылки как формулу
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// exportPages читает ссылки пользователя постранично по курсору
+type exportPages struct {
+	service *services.URLShortenerService
+	ctx     context.Context
+	params  services.ListParams
+	done    bool
+	count   int
+}
+
+// next отдает очередную страницу, пустую - когда ссылки кончились
+func (p *exportPages) next() ([]repository.URLRecord, error) {
+	if p.done {
+		return nil, nil
+	}
+	urls, cursor, err := p.service.FindUserURLs(p.ctx, p.params)
+	if err != nil {
+		return nil, err
+	}
+	p.params.Cursor, p.done = cursor, cursor == ""
+	p.count += len(urls)
+	return urls, nil
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ExportUserURLs выгружает ссылки пользователя в CSV или JSON, фильтры те же, что у GetUserURLs
+func (h *Handler) ExportUserURLs(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got ExportUserURLs request")
+	userID := userIDFromReq(req)
+	if userID == "" {
+		writeAPIError(res, req, ErrUnauthorized)
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	if format != exportFormatCSV && format != exportFormatJSON {
+		writeAPIError(res, req, fmt.Errorf("%w: %q, expected csv or json", ErrUnknownFormat, format))
+		return
+	}
+	params, err := listParams(req, userID)
+	if err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+	// выгрузка идет по всем страницам, курсор и limit клиента к ней не относятся
+	params.Cursor, params.Limit = "", exportPageSize
+	pages := &exportPages{service: h.service, ctx: req.Context(), params: params}
+	// первая страница читается до заголовков, чтобы ошибку запроса еще можно было вернуть статусом
+	first, err := pages.next()
+	if err != nil {
+		writeAPIError(res, req, fmt.Errorf("error while getting user urls: %w", err))
+		return
+	}
+
+	rc := http.NewResponseController(res)
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+	if format == exportFormatJSON {
+		err = h.exportJSON(res, rc, first, pages)
+	} else {
+		err = h.exportCSV(res, rc, first, pages)
+	}
+	if err != nil {
+		// заголовки уже ушли, остается только оборвать выгрузку
+		logger.Sugaarz.Errorw("error while exporting urls", "err", err)
+		return
+	}
+	logger.Sugaarz.Debugw("sent ExportUserURLs response", "urls", pages.count)
+}
+
+func (h *Handler) exportCSV(res http.ResponseWriter, rc *http.ResponseController, urls []repository.URLRecord, pages *exportPages) error {
+	res.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	w := csv.NewWriter(res)
+	if err := w.Write(exportCSVHeader); err != nil {
+		return err
+	}
+	for len(urls) > 0 {
+		for _, rec := range urls {
+			item := h.exportURL(rec)
+			createdAt := formatExportTime(&item.CreatedAt)
+			record := []string{
+				item.OriginalURL, item.Alias, formatExportTime(item.ExpiresAt), strings.Join(item.Tags, ","),
+				item.Title, item.ShortURL, createdAt,
+			}
+			for j := range record {
+				record[j] = csvSafe(record[j])
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		_ = rc.Flush()
+		var err error
+		if urls, err = pages.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportJSON пишет массив постранично, в памяти только текущая страница
+func (h *Handler) exportJSON(res http.ResponseWriter, rc *http.ResponseController, urls []repository.URLRecord, pages *exportPages) error {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if _, err := res.Write([]byte("[")); err != nil {
+		return err
+	}
+	sep := ""
+	for len(urls) > 0 {
+		for _, rec := range urls {
+			b, err := json.Marshal(h.exportURL(rec))
+			if err != nil {
+				return err
+			}
+			if _, err := res.Write(append([]byte(sep), b...)); err != nil {
+				return err
+			}
+			sep = ","
+		}
+		_ = rc.Flush()
+		var err error
+		if urls, err = pages.next(); err != nil {
+			return err
+		}
+	}
+	_, err := res.Write([]byte("]\n"))
+	return err
+}
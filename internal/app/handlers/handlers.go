@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
 	"github.com/stlesnik/url_shortener/internal/app/models"
 	"github.com/stlesnik/url_shortener/internal/app/repository"
 	"github.com/stlesnik/url_shortener/internal/app/services"
 	"github.com/stlesnik/url_shortener/internal/logger"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Handler struct {
@@ -30,25 +34,115 @@ func (h *Handler) getLongURLFromReq(req *http.Request) (string, error) {
 	if longURLStr == "" {
 		return "", ErrDidntGetURL
 	}
-	err = h.service.ValidateURL(longURLStr)
-	if err != nil {
-		return "", fmt.Errorf("got incorrect url to shorten: url=%v, err=%v: %w", longURLStr, err, ErrInvalidURL)
+	if err := h.service.ValidateURL(longURLStr); err != nil {
+		return "", err
 	}
 	return longURLStr, nil
 }
 
+func userIDFromReq(req *http.Request) string {
+	userID, _ := middleware.UserIDFromContext(req.Context())
+	return userID
+}
+
+// shortenOptions собирает параметры новой ссылки из запроса
+func (h *Handler) shortenOptions(req *http.Request, link models.LinkOptions) (services.ShortenOptions, error) {
+	return h.service.NewShortenOptions(userIDFromReq(req), middleware.IsAnonymous(req.Context()), linkParams(link))
+}
+
+func linkParams(link models.LinkOptions) services.LinkParams {
+	return services.LinkParams{
+		ExpiresAt:      link.ExpiresAt,
+		TTLSeconds:     link.TTLSeconds,
+		Password:       link.Password,
+		RedirectCode:   link.RedirectCode,
+		Title:          link.Title,
+		Tags:           link.Tags,
+		Passthrough:    link.Passthrough,
+		Targets:        targetRules(link.Targets),
+		Variants:       variants(link.Variants),
+		StickyVariants: link.StickyVariants,
+	}
+}
+
+func variants(items []models.Variant) repository.Variants {
+	if items == nil {
+		return nil
+	}
+	converted := make(repository.Variants, 0, len(items))
+	for _, v := range items {
+		converted = append(converted, repository.Variant(v))
+	}
+	return converted
+}
+
+// patchVariants: nil оставляет сплит как есть, пустой массив выключает его
+func patchVariants(items *[]models.Variant) *repository.Variants {
+	if items == nil {
+		return nil
+	}
+	converted := variants(*items)
+	return &converted
+}
+
+func apiVariants(items repository.Variants) []models.Variant {
+	if len(items) == 0 {
+		return nil
+	}
+	converted := make([]models.Variant, 0, len(items))
+	for _, v := range items {
+		converted = append(converted, models.Variant(v))
+	}
+	return converted
+}
+
+func targetRules(rules []models.TargetRule) repository.TargetRules {
+	if rules == nil {
+		return nil
+	}
+	converted := make(repository.TargetRules, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, repository.TargetRule(rule))
+	}
+	return converted
+}
+
+// patchTargets: nil оставляет правила как есть, пустой массив удаляет их
+func patchTargets(rules *[]models.TargetRule) *repository.TargetRules {
+	if rules == nil {
+		return nil
+	}
+	converted := targetRules(*rules)
+	return &converted
+}
+
+func apiTargetRules(rules repository.TargetRules) []models.TargetRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	converted := make([]models.TargetRule, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, models.TargetRule(rule))
+	}
+	return converted
+}
+
 func (h *Handler) SaveURL(res http.ResponseWriter, req *http.Request) {
 	//get long url from body
 	longURLStr, err := h.getLongURLFromReq(req)
 	if err != nil {
-		WriteError(res, err.Error(), http.StatusBadRequest, true)
+		writeTextError(res, err)
+		return
+	}
+	opts, err := h.shortenOptions(req, models.LinkOptions{})
+	if err != nil {
+		writeTextError(res, err)
 		return
 	}
 	//generate and save short url
-	shortURL, isDouble, errText := h.service.CreateSavePrepareShortURL(req.Context(), longURLStr)
-	if errText != "" {
-		logger.Sugaarz.Errorw(errText)
-		WriteError(res, errText, http.StatusInternalServerError, true)
+	shortURL, isDouble, err := h.service.CreateShortURL(req.Context(), longURLStr, opts)
+	if err != nil {
+		writeTextError(res, err)
 		return
 	}
 
@@ -68,43 +162,110 @@ func (h *Handler) SaveURL(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// GetLongURL обслуживает и GET, и POST: POST приходит из формы ввода пароля
 func (h *Handler) GetLongURL(res http.ResponseWriter, req *http.Request) {
-	URLHash := chi.URLParam(req, "id")
-	longURLStr, err := h.service.GetLongURLFromDB(req.Context(), URLHash)
-	if err == nil {
-		res.Header().Set("Location", longURLStr)
-		res.WriteHeader(http.StatusTemporaryRedirect)
-	} else {
+	URLHash, preview := previewRequested(req)
+	rec, err := h.service.GetLink(req.Context(), URLHash)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrURLDeleted):
+		WriteError(res, "Short url is deleted", http.StatusGone, false)
+		return
+	case errors.Is(err, services.ErrURLExpired):
+		WriteError(res, "Short url is expired", http.StatusGone, false)
+		return
+	default:
 		WriteError(res, "Short url not found", http.StatusBadRequest, false)
 		return
 	}
+
+	extra := extraPath(req)
+	if extra != "" && rec.Passthrough == "" {
+		// без передачи пути /{id}/... - это чужой адрес, а не ссылка
+		WriteError(res, "Short url not found", http.StatusBadRequest, false)
+		return
+	}
+	if rec.IsProtected() && !h.unlockLink(res, req, rec) {
+		return
+	}
+	if preview {
+		if reason, blocked := h.service.CheckRedirect(rec.OriginalURL); blocked {
+			writeWarning(res, URLHash, rec.OriginalURL, reason)
+			return
+		}
+		h.writePreview(res, req, rec)
+		return
+	}
+	target, variant := h.chooseDestination(res, req, rec)
+	location, err := services.Destination(target, rec.Passthrough, extra, req.URL.Query())
+	if err != nil {
+		logger.Sugaarz.Errorw("error building destination", "short", URLHash, "err", err)
+		WriteError(res, "Failed to build destination url", http.StatusInternalServerError, true)
+		return
+	}
+	if reason, blocked := h.service.CheckRedirect(location); blocked {
+		writeWarning(res, URLHash, location, reason)
+		return
+	}
+	h.service.RecordClick(URLHash, services.Visit{
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		ClientIP:  middleware.ClientIP(req),
+		Variant:   variant,
+	})
+	res.Header().Set("Location", location)
+	if res.Header().Get("Cache-Control") == "" {
+		res.Header().Set("Cache-Control", h.service.RedirectCacheControl(rec, time.Now()))
+	}
+	if req.Method == http.MethodPost {
+		// после формы браузер должен перейти по адресу GET-запросом, а не повторять POST
+		res.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	res.WriteHeader(h.service.RedirectCode(rec))
+}
+
+// extraPath - часть пути после /{id} в экранированном виде, пустая для обычного перехода
+func extraPath(req *http.Request) string {
+	_, extra, _ := strings.Cut(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	return extra
 }
 
 func (h *Handler) APIPrepareShortURL(res http.ResponseWriter, req *http.Request) {
 	logger.Sugaarz.Debugw("got APIPrepareShortURL request")
 	var apiReq models.APIRequestPrepareShURL
-	err := json.NewDecoder(req.Body).Decode(&apiReq)
-	if err != nil {
-		logger.Sugaarz.Errorw("error decoding body", "err", err)
-		WriteError(res, "Failed to decode body", http.StatusInternalServerError, true)
+	if err := json.NewDecoder(req.Body).Decode(&apiReq); err != nil {
+		writeAPIError(res, req, fmt.Errorf("%w: %v", ErrDecodeBody, err))
 		return
 	}
-	validateErr := h.service.ValidateURL(apiReq.LongURL)
-	if validateErr != nil {
-		logger.Sugaarz.Errorw("got incorrect url to shorten: "+apiReq.LongURL, "err", err)
-		WriteError(res, "got incorrect url to shorten: "+apiReq.LongURL, http.StatusInternalServerError, true)
+	if err := h.service.ValidateURL(apiReq.LongURL); err != nil {
+		writeAPIError(res, req, err)
 		return
 	}
 
-	shortURL, isDouble, errText := h.service.CreateSavePrepareShortURL(req.Context(), apiReq.LongURL)
-	if errText != "" {
-		logger.Sugaarz.Errorw(errText)
-		WriteError(res, errText, http.StatusInternalServerError, true)
+	opts, err := h.shortenOptions(req, apiReq.LinkOptions)
+	if err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+
+	var (
+		shortURL string
+		isDouble bool
+	)
+	if apiReq.Alias != "" {
+		shortURL, isDouble, err = h.service.CreateSavePrepareAliasURL(req.Context(), apiReq.LongURL, apiReq.Alias, opts)
+	} else {
+		shortURL, isDouble, err = h.service.CreateShortURL(req.Context(), apiReq.LongURL, opts)
+	}
+	if err != nil {
+		writeAPIError(res, req, err)
 		return
 	}
 
 	apiResp := models.APIResponsePrepareShURL{
-		ShortURL: shortURL,
+		ShortURL:  shortURL,
+		ExpiresAt: opts.ExpiresAt,
 	}
 	res.Header().Set("Content-Type", "application/json")
 	if isDouble {
@@ -126,57 +287,240 @@ func (h *Handler) APIPrepareBatchShortURL(res http.ResponseWriter, req *http.Req
 	//process request
 	logger.Sugaarz.Debugw("got APISaveBatchURL request")
 	var apiBatchReq []models.APIRequestPrepareBatchShURL
-	err := json.NewDecoder(req.Body).Decode(&apiBatchReq)
-	if err != nil {
-		logger.Sugaarz.Errorw("error decoding body", "err", err)
-		WriteError(res, "Failed to decode body", http.StatusInternalServerError, true)
+	if err := json.NewDecoder(req.Body).Decode(&apiBatchReq); err != nil {
+		writeAPIError(res, req, fmt.Errorf("%w: %v", ErrDecodeBody, err))
 		return
 	}
-	//prepare db and response
-	var (
-		apiBatchResp     []models.APIResponsePrepareBatchShURL
-		batch            []repository.URLPair
-		validationErrors []error
-	)
-	for _, obj := range apiBatchReq {
-		validateErr := h.service.ValidateURL(obj.LongURL)
-		if validateErr != nil {
-			logger.Sugaarz.Errorw("got incorrect url to shorten: "+obj.LongURL, "err", validateErr)
-			validationErrors = append(validationErrors, validateErr)
-		} else {
-			urlHash, err := h.service.CreateShortURLHash(obj.LongURL)
-			if err != nil {
-				logger.Sugaarz.Errorw("Failed to create short URL", "err", err)
-				WriteError(res, "Failed to create short URL,", http.StatusInternalServerError, true)
-				return
-			}
-			batch = append(batch, repository.URLPair{URLHash: urlHash, LongURL: obj.LongURL})
-			apiBatchResp = append(apiBatchResp, models.APIResponsePrepareBatchShURL{
-				CorrelationID: obj.CorrelationID, ShortURL: h.service.PrepareShortURL(urlHash)})
-		}
+	//validate items, invalid ones stay in the batch to be reported back
+	userID, anonymous := userIDFromReq(req), middleware.IsAnonymous(req.Context())
+	entries := make([]services.BatchEntry, len(apiBatchReq))
+	for i, obj := range apiBatchReq {
+		entries[i] = h.service.NewBatchEntry(obj.LongURL, userID, anonymous, linkParams(obj.LinkOptions))
 	}
 	//save batch
-	txErr := h.service.SaveBatchShortURL(req.Context(), batch)
-	if txErr != nil {
-		logger.Sugaarz.Errorw("error while saving batch", "err", txErr)
-		WriteError(res, "error while saving batch", http.StatusInternalServerError, true)
+	if err := h.service.SaveBatchShortURL(req.Context(), entries); err != nil {
+		writeAPIError(res, req, fmt.Errorf("error while saving batch: %w", err))
 		return
 	}
 
 	//create response
+	apiBatchResp := make([]models.APIResponsePrepareBatchShURL, 0, len(entries))
+	allCreated := true
+	for i, entry := range entries {
+		item := models.APIResponsePrepareBatchShURL{
+			CorrelationID: apiBatchReq[i].CorrelationID,
+			ShortURL:      entry.ShortURL,
+			Status:        string(entry.Status),
+		}
+		if entry.Err != nil {
+			item.Error = entry.Err.Error()
+		}
+		allCreated = allCreated && entry.Status == services.BatchCreated
+		apiBatchResp = append(apiBatchResp, item)
+	}
+	// 201 - только если создано все, иначе клиент разбирает статусы по элементам
+	code := http.StatusCreated
+	if !allCreated {
+		code = http.StatusMultiStatus
+	}
 	res.Header().Set("Content-Type", "application/json")
-	res.WriteHeader(http.StatusCreated)
+	res.WriteHeader(code)
 	if err := json.NewEncoder(res).Encode(apiBatchResp); err != nil {
 		logger.Sugaarz.Errorw("error encoding body", "err", err)
 		WriteError(res, "Failed to encode body", http.StatusInternalServerError, true)
 		return
 	}
-	if len(validationErrors) > 0 {
-		logger.Sugaarz.Errorf("got %v errors while processing request: %w", len(validationErrors), errors.Join(validationErrors...))
-	}
 	logger.Sugaarz.Debugw("sent APISaveBatchURL response")
 }
 
+// NextCursorHeader - курсор следующей страницы списка ссылок, его нет на последней странице
+const NextCursorHeader = "X-Next-Cursor"
+
+// listParams разбирает ?tag=, ?q=, ?sort=, ?cursor= и ?limit=. Тегов может быть несколько:
+// повтором параметра или через запятую
+func listParams(req *http.Request, userID string) (services.ListParams, error) {
+	query := req.URL.Query()
+	params := services.ListParams{
+		UserID: userID,
+		Search: query.Get("q"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+	for _, tag := range query["tag"] {
+		params.Tags = append(params.Tags, strings.Split(tag, ",")...)
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return services.ListParams{}, fmt.Errorf("limit must be a positive number, got %q: %w", raw, services.ErrInvalidListQuery)
+		}
+		params.Limit = limit
+	}
+	return params, nil
+}
+
+func (h *Handler) GetUserURLs(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got GetUserURLs request")
+	userID := userIDFromReq(req)
+	if userID == "" {
+		writeAPIError(res, req, ErrUnauthorized)
+		return
+	}
+	params, err := listParams(req, userID)
+	if err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+	urls, next, err := h.service.FindUserURLs(req.Context(), params)
+	if err != nil {
+		writeAPIError(res, req, fmt.Errorf("error while getting user urls: %w", err))
+		return
+	}
+	if next != "" {
+		res.Header().Set(NextCursorHeader, next)
+	}
+	if len(urls) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	apiResp := make([]models.APIResponseUserURL, 0, len(urls))
+	for _, rec := range urls {
+		apiResp = append(apiResp, models.APIResponseUserURL{
+			ShortURL:    h.service.PrepareShortURL(rec.ShortURL),
+			OriginalURL: rec.OriginalURL,
+			Title:       rec.Title,
+			Tags:        rec.Tags,
+			Targets:     apiTargetRules(rec.Targets),
+			Variants:    apiVariants(rec.Variants),
+			CreatedAt:   rec.CreatedAt,
+			ExpiresAt:   rec.ExpiresAt,
+		})
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(res).Encode(apiResp); err != nil {
+		logger.Sugaarz.Errorw("error encoding body", "err", err)
+		WriteError(res, "Failed to encode body", http.StatusInternalServerError, true)
+		return
+	}
+	logger.Sugaarz.Debugw("sent GetUserURLs response")
+}
+
+func (h *Handler) DeleteUserURLs(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got DeleteUserURLs request")
+	userID := userIDFromReq(req)
+	if userID == "" {
+		writeAPIError(res, req, ErrUnauthorized)
+		return
+	}
+	var hashes []string
+	if err := json.NewDecoder(req.Body).Decode(&hashes); err != nil {
+		writeAPIError(res, req, fmt.Errorf("%w: %v", ErrDecodeBody, err))
+		return
+	}
+	if err := h.service.DeleteUserURLs(userID, hashes); err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) UpdateShortURL(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got UpdateShortURL request")
+	userID := userIDFromReq(req)
+	if userID == "" {
+		writeAPIError(res, req, ErrUnauthorized)
+		return
+	}
+	var apiReq models.APIRequestUpdateShURL
+	if err := json.NewDecoder(req.Body).Decode(&apiReq); err != nil {
+		writeAPIError(res, req, fmt.Errorf("%w: %v", ErrDecodeBody, err))
+		return
+	}
+
+	URLHash := chi.URLParam(req, "id")
+	rec, err := h.service.UpdateLink(req.Context(), URLHash, userID, repository.URLPatch{
+		OriginalURL:    apiReq.LongURL,
+		RedirectCode:   apiReq.RedirectCode,
+		Title:          apiReq.Title,
+		Tags:           apiReq.Tags,
+		Passthrough:    apiReq.Passthrough,
+		Targets:        patchTargets(apiReq.Targets),
+		Variants:       patchVariants(apiReq.Variants),
+		StickyVariants: apiReq.StickyVariants,
+	})
+	if err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+
+	apiResp := models.APIResponseLink{
+		ShortURL:       h.service.PrepareShortURL(rec.ShortURL),
+		OriginalURL:    rec.OriginalURL,
+		RedirectCode:   h.service.RedirectCode(rec),
+		Title:          rec.Title,
+		Tags:           rec.Tags,
+		Passthrough:    rec.Passthrough,
+		Targets:        apiTargetRules(rec.Targets),
+		Variants:       apiVariants(rec.Variants),
+		StickyVariants: rec.StickyVariants,
+		ExpiresAt:      rec.ExpiresAt,
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(res).Encode(apiResp); err != nil {
+		logger.Sugaarz.Errorw("error encoding body", "err", err)
+		WriteError(res, "Failed to encode body", http.StatusInternalServerError, true)
+		return
+	}
+	logger.Sugaarz.Debugw("sent UpdateShortURL response")
+}
+
+func (h *Handler) GetLinkStats(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got GetLinkStats request")
+	URLHash := chi.URLParam(req, "id")
+	stats, err := h.service.GetLinkStats(req.Context(), URLHash, userIDFromReq(req))
+	if err != nil {
+		writeAPIError(res, req, err)
+		return
+	}
+
+	apiResp := models.APIResponseLinkStats{
+		ShortURL:   h.service.PrepareShortURL(URLHash),
+		Total:      stats.Total,
+		ByDay:      stats.ByDay,
+		ByReferrer: stats.ByReferrer,
+		ByBrowser:  stats.ByBrowser,
+		ByVariant:  stats.ByVariant,
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(res).Encode(apiResp); err != nil {
+		logger.Sugaarz.Errorw("error encoding body", "err", err)
+		WriteError(res, "Failed to encode body", http.StatusInternalServerError, true)
+		return
+	}
+	logger.Sugaarz.Debugw("sent GetLinkStats response")
+}
+
+func (h *Handler) GetInternalStats(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got GetInternalStats request")
+	stats, err := h.service.GetServiceStats(req.Context())
+	if err != nil {
+		writeAPIError(res, req, fmt.Errorf("error while getting service stats: %w", err))
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(res).Encode(models.APIResponseInternalStats{URLs: stats.URLs, Users: stats.Users}); err != nil {
+		logger.Sugaarz.Errorw("error encoding body", "err", err)
+		WriteError(res, "Failed to encode body", http.StatusInternalServerError, true)
+		return
+	}
+	logger.Sugaarz.Debugw("sent GetInternalStats response")
+}
+
 func (h *Handler) PingDB(res http.ResponseWriter, req *http.Request) {
 	logger.Sugaarz.Debugw("got PingDB request")
 	err := h.service.PingDB(req.Context())
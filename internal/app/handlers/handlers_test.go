@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/models"
 	"github.com/stlesnik/url_shortener/internal/app/repository"
 	"github.com/stlesnik/url_shortener/internal/app/services"
 	"github.com/stlesnik/url_shortener/internal/app/services/mocks"
@@ -14,8 +19,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHandler_getLongURLFromReq(t *testing.T) {
@@ -49,7 +59,7 @@ func TestHandler_getLongURLFromReq(t *testing.T) {
 		{
 			name:     "bad url",
 			longURL:  "://mbrgaoyhv.yandex",
-			expected: expected{longURLStr: "", error: "got incorrect url to shorten: url=://mbrgaoyhv.yandex, err=got incorrect url to shorten: url=://mbrgaoyhv.yandex, err= parse \"://mbrgaoyhv.yandex\": missing protocol scheme: invalid url to shorten"},
+			expected: expected{longURLStr: "", error: "got incorrect url to shorten: url=://mbrgaoyhv.yandex, err=parse \"://mbrgaoyhv.yandex\": missing protocol scheme: invalid url"},
 		},
 	}
 
@@ -131,6 +141,21 @@ func TestHandler_SaveURL(t *testing.T) {
 	}
 }
 
+type recordURLMatcher string
+
+func (m recordURLMatcher) Matches(x interface{}) bool {
+	rec, ok := x.(repository.URLRecord)
+	return ok && rec.OriginalURL == string(m)
+}
+
+func (m recordURLMatcher) String() string {
+	return "is record with original url " + string(m)
+}
+
+func recordWithURL(longURL string) gomock.Matcher {
+	return recordURLMatcher(longURL)
+}
+
 func TestHandler_SaveURL_Conflict_WithMockRepo(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -139,11 +164,11 @@ func TestHandler_SaveURL_Conflict_WithMockRepo(t *testing.T) {
 	const longURL = "http://example.com"
 	gomock.InOrder(
 		m.EXPECT().
-			Save(context.Background(), gomock.Any(), longURL).
+			Save(context.Background(), recordWithURL(longURL)).
 			Return(false, nil).
 			Times(1),
 		m.EXPECT().
-			Save(context.Background(), gomock.Any(), longURL).
+			Save(context.Background(), recordWithURL(longURL)).
 			Return(true, nil).
 			Times(1),
 	)
@@ -152,6 +177,13 @@ func TestHandler_SaveURL_Conflict_WithMockRepo(t *testing.T) {
 	err := logger.InitLogger(cfg.Environment)
 	require.NoError(t, err)
 	service := services.New(m, cfg)
+	urlHash, err := service.CreateShortURLHash(longURL)
+	require.NoError(t, err)
+	// при конфликте сервис уточняет, под каким id url уже лежит
+	m.EXPECT().
+		GetByOriginalURL(context.Background(), longURL).
+		Return(repository.URLRecord{ShortURL: urlHash, OriginalURL: longURL}, nil).
+		Times(1)
 	handler := New(service)
 
 	req1 := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(longURL))
@@ -196,7 +228,9 @@ func TestHandler_GetLongURL(t *testing.T) {
 	err := logger.InitLogger(cfg.Environment)
 	require.NoError(t, err)
 	repo := repository.NewInMemoryRepository()
-	_, _ = repo.Save(context.Background(), "_SGMGLQIsIM=", "http://mbrgaoyhv.yandex")
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "_SGMGLQIsIM=", OriginalURL: "http://mbrgaoyhv.yandex", UserID: "user"})
+	expired := time.Now().Add(-time.Minute)
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "expired", OriginalURL: "https://vk.com", ExpiresAt: &expired})
 	service := services.New(repo, cfg)
 	handler := New(service)
 
@@ -218,6 +252,14 @@ func TestHandler_GetLongURL(t *testing.T) {
 				location:   "http://mbrgaoyhv.yandex",
 			},
 		},
+		{
+			name: "Url expired",
+			path: "/expired",
+			expected: expected{
+				statusCode: http.StatusGone,
+				location:   "",
+			},
+		},
 		{
 			name: "Url dont exist",
 			path: "/invalid-path",
@@ -247,19 +289,459 @@ func TestHandler_GetLongURL(t *testing.T) {
 	}
 }
 
-func TestHandler_ApiPrepareShortURL(t *testing.T) {
+func TestHandler_GetLongURL_Passthrough(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "plain", OriginalURL: "https://example.com/plain?utm_source=link"})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "override", OriginalURL: "https://example.com/docs?utm_source=link", Passthrough: services.PassthroughOverride})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "keep", OriginalURL: "https://example.com/keep?utm_source=link", Passthrough: services.PassthroughKeep})
+	handler := New(services.New(repo, cfg))
+
+	tests := []struct {
+		name       string
+		target     string
+		statusCode int
+		location   string
+	}{
+		{"plain link ignores query", "/plain?utm_source=x", http.StatusTemporaryRedirect, "https://example.com/plain?utm_source=link"},
+		{"plain link with extra path", "/plain/extra", http.StatusBadRequest, ""},
+		{"override without extra", "/override", http.StatusTemporaryRedirect, "https://example.com/docs?utm_source=link"},
+		{"override path and query", "/override/extra/path?utm_source=x&ref=y", http.StatusTemporaryRedirect, "https://example.com/docs/extra/path?ref=y&utm_source=x"},
+		{"keep path and query", "/keep/extra/path?utm_source=x&ref=y", http.StatusTemporaryRedirect, "https://example.com/keep/extra/path?ref=y&utm_source=link"},
+		{"escaped path stays escaped", "/keep/a%2Fb", http.StatusTemporaryRedirect, "https://example.com/keep/a%2Fb?utm_source=link"},
+		{"dot segments do not escape link path", "/keep/../../admin", http.StatusTemporaryRedirect, "https://example.com/keep/admin?utm_source=link"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", id)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+			w := httptest.NewRecorder()
+			handler.GetLongURL(w, r)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestHandler_GetLongURL_Targets(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "app", OriginalURL: "https://shop.ru", Targets: repository.TargetRules{
+		{OS: services.OSiOS, URL: "https://apps.apple.com/app/shop"},
+		{OS: services.OSAndroid, Device: services.DeviceMobile, URL: "https://play.google.com/store/apps/details?id=ru.shop"},
+	}})
+	handler := New(services.New(repo, cfg))
+
+	tests := []struct {
+		name      string
+		userAgent string
+		location  string
+	}{
+		{"iPhone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", "https://apps.apple.com/app/shop"},
+		{"Android phone", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", "https://play.google.com/store/apps/details?id=ru.shop"},
+		{"Android tablet falls through", "Mozilla/5.0 (Linux; Android 14; SM-X710) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", "https://shop.ru"},
+		{"Desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", "https://shop.ru"},
+		{"No user agent", "", "https://shop.ru"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/app", nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", "app")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+			w := httptest.NewRecorder()
+			handler.GetLongURL(w, r)
+
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+			assert.Equal(t, "User-Agent", w.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestHandler_GetLongURL_Variants(t *testing.T) {
 	cfg := &config.Config{BaseURL: "http://localhost:8000"}
 	err := logger.InitLogger(cfg.Environment)
 	require.NoError(t, err)
 	repo := repository.NewInMemoryRepository()
+	split := repository.Variants{{Name: "a", URL: "https://shop.ru/a", Weight: 1}, {Name: "b", URL: "https://shop.ru/b", Weight: 1}}
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "sticky", OriginalURL: "https://shop.ru/sticky", Variants: split, StickyVariants: true})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "random", OriginalURL: "https://shop.ru/random", Variants: split})
+	handler := New(services.New(repo, cfg))
+
+	follow := func(id string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rc := chi.NewRouteContext()
+		rc.URLParams.Add("id", id)
+		ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rc)
+		r = r.WithContext(middleware.WithUserID(ctx, "visitor"))
+		w := httptest.NewRecorder()
+		handler.GetLongURL(w, r)
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		return w
+	}
+
+	w := follow("sticky", nil)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, VariantCookieName, cookies[0].Name)
+	assert.Equal(t, "/sticky", cookies[0].Path)
+	assert.Equal(t, "https://shop.ru/"+cookies[0].Value, w.Header().Get("Location"))
+
+	// закрепленный вариант важнее выбора по посетителю
+	other := "a"
+	if cookies[0].Value == "a" {
+		other = "b"
+	}
+	w = follow("sticky", &http.Cookie{Name: VariantCookieName, Value: other})
+	assert.Equal(t, "https://shop.ru/"+other, w.Header().Get("Location"))
+	assert.Empty(t, w.Result().Cookies(), "cookie is not reissued for the assigned variant")
+
+	w = follow("random", nil)
+	assert.Empty(t, w.Result().Cookies())
+	assert.Contains(t, []string{"https://shop.ru/a", "https://shop.ru/b"}, w.Header().Get("Location"))
+}
+
+func TestHandler_GetLongURL_Blocklisted(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000", CheckOnRedirect: true}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "flagged", OriginalURL: "https://evil.com/login"})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "clean", OriginalURL: "https://ya.ru"})
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("domain:evil.com\n"), 0o600))
+	checker, err := services.NewBlocklistChecker(path)
+	require.NoError(t, err)
 	service := services.New(repo, cfg)
+	service.SetURLChecker(checker)
+	handler := New(service)
+
+	follow := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		rc := chi.NewRouteContext()
+		rc.URLParams.Add("id", strings.TrimPrefix(r.URL.Path, "/"))
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+		w := httptest.NewRecorder()
+		handler.GetLongURL(w, r)
+		return w
+	}
+
+	w := follow("/flagged")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), "domain evil.com is blocklisted")
+	assert.NotContains(t, w.Body.String(), `href="https://evil.com`)
+
+	w = follow("/flagged+")
+	assert.Equal(t, http.StatusForbidden, w.Code, "preview does not link to a flagged url either")
+
+	w = follow("/clean")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	// API раскрытия тоже не выдает адрес отключенной ссылки
+	r := httptest.NewRequest(http.MethodGet, "/api/expand?short=flagged", nil)
+	w = httptest.NewRecorder()
+	handler.ExpandShortURL(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"url_blocked"`)
+	assert.NotContains(t, w.Body.String(), "https://evil.com/login")
+
+	r = httptest.NewRequest(http.MethodPost, "/api/expand/batch", strings.NewReader(`["flagged","clean"]`))
+	w = httptest.NewRecorder()
+	handler.ExpandBatch(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"url_blocked"`)
+	assert.NotContains(t, w.Body.String(), "https://evil.com/login")
+
+	cfg.CheckOnRedirect = false
+	w = follow("/flagged")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	// новые ссылки на такой адрес не сохраняются вовсе
+	r = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://www.evil.com/"}`))
+	w = httptest.NewRecorder()
+	handler.APIPrepareShortURL(w, r)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"url_malicious"`)
+}
+
+func TestHandler_GetLongURL_Preview(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "_SGMGLQIsIM=", OriginalURL: "http://mbrgaoyhv.yandex", CreatedAt: created})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	t.Run("Html by suffix", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/_SGMGLQIsIM=+", nil)
+		rc := chi.NewRouteContext()
+		rc.URLParams.Add("id", "_SGMGLQIsIM=+")
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+		w := httptest.NewRecorder()
+		handler.GetLongURL(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "http://mbrgaoyhv.yandex")
+		assert.Contains(t, w.Body.String(), "2025-03-01")
+	})
+
+	t.Run("Json by query", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/_SGMGLQIsIM=?preview=1", nil)
+		r.Header.Set("Accept", "application/json")
+		rc := chi.NewRouteContext()
+		rc.URLParams.Add("id", "_SGMGLQIsIM=")
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+		w := httptest.NewRecorder()
+		handler.GetLongURL(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp models.APIResponseLinkPreview
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "http://localhost:8000/_SGMGLQIsIM=", resp.ShortURL)
+		assert.Equal(t, "http://mbrgaoyhv.yandex", resp.OriginalURL)
+		assert.True(t, created.Equal(resp.CreatedAt))
+	})
+}
+
+func TestHandler_GetLongURL_Protected(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	service := services.New(repo, cfg)
+	hash, err := service.HashPassword("secret")
+	require.NoError(t, err)
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "docs", OriginalURL: "https://docs.internal/report", PasswordHash: hash})
 	handler := New(service)
 
 	tests := []struct {
-		name         string
-		body         string
-		expectedCode int
-		expectedBody string
+		name       string
+		method     string
+		header     string
+		form       string
+		accept     string
+		statusCode int
+		location   string
+	}{
+		{name: "No password", method: http.MethodGet, statusCode: http.StatusUnauthorized},
+		{name: "Form for browser", method: http.MethodGet, accept: "text/html", statusCode: http.StatusUnauthorized},
+		{name: "Wrong header", method: http.MethodGet, header: "wrong", statusCode: http.StatusUnauthorized},
+		{name: "Right header", method: http.MethodGet, header: "secret", statusCode: http.StatusTemporaryRedirect, location: "https://docs.internal/report"},
+		{name: "Right form", method: http.MethodPost, form: "password=secret", statusCode: http.StatusSeeOther, location: "https://docs.internal/report"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/docs", strings.NewReader(tt.form))
+			if tt.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.header != "" {
+				r.Header.Set(PasswordHeader, tt.header)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", "docs")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+			w := httptest.NewRecorder()
+			handler.GetLongURL(w, r)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+			if tt.location != "" {
+				// редирект из кеша браузера обошел бы пароль
+				assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			}
+			if tt.accept == "text/html" {
+				assert.Contains(t, w.Body.String(), `<form method="post">`)
+			}
+		})
+	}
+
+	t.Run("Spoofed X-Forwarded-For does not reset the ip limit", func(t *testing.T) {
+		codes := make([]int, 0, 11)
+		for i := 0; i < 11; i++ {
+			r := httptest.NewRequest(http.MethodGet, "/docs", nil)
+			r.RemoteAddr = "203.0.113.5:1234"
+			r.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+			r.Header.Set(PasswordHeader, "wrong")
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", "docs")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+			w := httptest.NewRecorder()
+			handler.GetLongURL(w, r)
+			codes = append(codes, w.Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, codes[len(codes)-1])
+	})
+}
+
+func TestHandler_UpdateShortURL(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000", RedirectCode: http.StatusFound}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "promo", OriginalURL: "https://shop.ru/sale", UserID: "owner"})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	redirect := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/promo", nil)
+		rc := chi.NewRouteContext()
+		rc.URLParams.Add("id", "promo")
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+		w := httptest.NewRecorder()
+		handler.GetLongURL(w, r)
+		return w.Code
+	}
+	// без явного кода действует значение из конфига
+	require.Equal(t, http.StatusFound, redirect())
+
+	tests := []struct {
+		name           string
+		id             string
+		userID         string
+		body           string
+		statusCode     int
+		expectedReason string
+	}{
+		{name: "Not owner", id: "promo", userID: "stranger", body: `{"redirect_code":301}`, statusCode: http.StatusForbidden},
+		{name: "Unknown url", id: "missing", userID: "owner", body: `{"redirect_code":301}`, statusCode: http.StatusNotFound},
+		{name: "Bad code", id: "promo", userID: "owner", body: `{"redirect_code":303}`, statusCode: http.StatusBadRequest, expectedReason: "invalid_redirect_code"},
+		{name: "Bad url", id: "promo", userID: "owner", body: `{"url":"not a url"}`, statusCode: http.StatusBadRequest, expectedReason: "invalid_url"},
+		{name: "Owner sets permanent", id: "promo", userID: "owner", body: `{"redirect_code":308}`, statusCode: http.StatusOK},
+		{name: "Owner changes destination", id: "promo", userID: "owner", body: `{"url":"https://shop.ru/winter"}`, statusCode: http.StatusOK},
+		{name: "Bad tag", id: "promo", userID: "owner", body: `{"tags":["a,b"]}`, statusCode: http.StatusBadRequest, expectedReason: "invalid_tags"},
+		{name: "Owner sets title and tags", id: "promo", userID: "owner", body: `{"title":" Winter sale ","tags":["Sale","promo","sale"]}`, statusCode: http.StatusOK},
+		{name: "Bad target os", id: "promo", userID: "owner", body: `{"targets":[{"os":"symbian","url":"https://shop.ru/app"}]}`, statusCode: http.StatusBadRequest, expectedReason: "invalid_targets"},
+		{name: "Target without condition", id: "promo", userID: "owner", body: `{"targets":[{"url":"https://shop.ru/app"}]}`, statusCode: http.StatusBadRequest, expectedReason: "invalid_targets"},
+		{name: "Owner sets targets", id: "promo", userID: "owner", body: `{"targets":[{"os":"iOS","url":"https://apps.apple.com/app/shop"}]}`, statusCode: http.StatusOK},
+		{name: "Single variant", id: "promo", userID: "owner", body: `{"variants":[{"url":"https://shop.ru/a","weight":1}]}`, statusCode: http.StatusBadRequest, expectedReason: "invalid_variants"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/api/shorten/"+tt.id, strings.NewReader(tt.body))
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", tt.id)
+			ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rc)
+			r = r.WithContext(middleware.WithUserID(ctx, tt.userID))
+
+			w := httptest.NewRecorder()
+			handler.UpdateShortURL(w, r)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.expectedReason != "" {
+				var apiErr models.APIError
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&apiErr))
+				assert.Equal(t, tt.expectedReason, apiErr.Error.Code)
+			}
+			if tt.statusCode == http.StatusOK {
+				var resp models.APIResponseLink
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Equal(t, http.StatusPermanentRedirect, resp.RedirectCode)
+				assert.Equal(t, "http://localhost:8000/promo", resp.ShortURL)
+			}
+		})
+	}
+	assert.Equal(t, http.StatusPermanentRedirect, redirect())
+	rec, err := repo.Get(context.Background(), "promo")
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.ru/winter", rec.OriginalURL)
+	assert.Equal(t, "Winter sale", rec.Title)
+	assert.Equal(t, repository.Tags{"promo", "sale"}, rec.Tags)
+	assert.Equal(t, repository.TargetRules{{OS: "ios", URL: "https://apps.apple.com/app/shop"}}, rec.Targets)
+}
+
+func TestHandler_GetQRCode(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "_SGMGLQIsIM=", OriginalURL: "http://mbrgaoyhv.yandex"})
+	expired := time.Now().Add(-time.Minute)
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "expired", OriginalURL: "https://vk.com", ExpiresAt: &expired})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	tests := []struct {
+		name        string
+		id          string
+		query       string
+		accept      string
+		statusCode  int
+		contentType string
+	}{
+		{name: "Png by default", id: "_SGMGLQIsIM=", statusCode: http.StatusOK, contentType: "image/png"},
+		{name: "Svg by accept", id: "_SGMGLQIsIM=", accept: "image/svg+xml", statusCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "Format overrides accept", id: "_SGMGLQIsIM=", query: "?format=png&size=128&ec=H", accept: "image/svg+xml", statusCode: http.StatusOK, contentType: "image/png"},
+		{name: "Unknown format", id: "_SGMGLQIsIM=", query: "?format=gif", statusCode: http.StatusBadRequest},
+		{name: "Bad size", id: "_SGMGLQIsIM=", query: "?size=10", statusCode: http.StatusBadRequest},
+		{name: "Bad level", id: "_SGMGLQIsIM=", query: "?ec=X", statusCode: http.StatusBadRequest},
+		{name: "Url expired", id: "expired", statusCode: http.StatusGone},
+		{name: "Url dont exist", id: "missing", statusCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.id+"/qr"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", tt.id)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+
+			w := httptest.NewRecorder()
+			handler.GetQRCode(w, r)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+				assert.NotEmpty(t, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestHandler_ApiPrepareShortURL(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000", ReservedAliases: []string{"api", "ping"}}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedCode   int
+		expectedBody   string
+		expectedReason string
 	}{
 		{
 			name:         "Good json case",
@@ -267,6 +749,72 @@ func TestHandler_ApiPrepareShortURL(t *testing.T) {
 			expectedCode: 201,
 			expectedBody: `{"result":"http://localhost:8000/ymMooIzfwh4="}`,
 		},
+		{
+			name:         "Alias case",
+			body:         `{"url":"https://ya.ru","alias":"my-link"}`,
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"result":"http://localhost:8000/my-link"}`,
+		},
+		{
+			name:         "Same alias for same url",
+			body:         `{"url":"https://ya.ru","alias":"my-link"}`,
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"result":"http://localhost:8000/my-link"}`,
+		},
+		{
+			name:           "Alias taken by another url",
+			body:           `{"url":"https://ok.ru","alias":"my-link"}`,
+			expectedCode:   http.StatusConflict,
+			expectedReason: "alias_taken",
+		},
+		{
+			name:           "Reserved alias",
+			body:           `{"url":"https://ok.ru","alias":"API"}`,
+			expectedCode:   http.StatusUnprocessableEntity,
+			expectedReason: "alias_reserved",
+		},
+		{
+			name:           "Invalid alias",
+			body:           `{"url":"https://ok.ru","alias":"no spaces"}`,
+			expectedCode:   http.StatusBadRequest,
+			expectedReason: "alias_invalid",
+		},
+		{
+			name:           "Link to the shortener itself",
+			body:           `{"url":"http://localhost:8000/ymMooIzfwh4="}`,
+			expectedCode:   http.StatusUnprocessableEntity,
+			expectedReason: "url_self_redirect",
+		},
+		{
+			name:           "Scheme not allowed",
+			body:           `{"url":"javascript:alert(1)"}`,
+			expectedCode:   http.StatusUnprocessableEntity,
+			expectedReason: "url_scheme_not_allowed",
+		},
+		{
+			name:           "Unsupported redirect code",
+			body:           `{"url":"https://ok.ru","redirect_code":303}`,
+			expectedCode:   http.StatusBadRequest,
+			expectedReason: "invalid_redirect_code",
+		},
+		{
+			name:           "Malformed body",
+			body:           `{"url":`,
+			expectedCode:   http.StatusBadRequest,
+			expectedReason: "invalid_body",
+		},
+		{
+			name:           "Invalid url",
+			body:           `{"url":"not a url"}`,
+			expectedCode:   http.StatusBadRequest,
+			expectedReason: "invalid_url",
+		},
+		{
+			name:         "Permanent redirect code",
+			body:         `{"url":"https://ok.ru","redirect_code":301}`,
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"result":"http://localhost:8000/Stly84Xz-d8="}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -277,17 +825,617 @@ func TestHandler_ApiPrepareShortURL(t *testing.T) {
 
 			require.Equal(t, tt.expectedCode, w.Code)
 			res := w.Result()
-			if tt.expectedCode == http.StatusCreated {
-				resJSONBytes, err := io.ReadAll(res.Body)
-				require.NoError(t, err)
-
+			resJSONBytes, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			if tt.expectedBody != "" {
 				resJSON := string(resJSONBytes)
 				assert.JSONEq(t, tt.expectedBody, resJSON)
 			}
-			err := res.Body.Close()
+			if tt.expectedReason != "" {
+				var apiErr models.APIError
+				require.NoError(t, json.Unmarshal(resJSONBytes, &apiErr))
+				assert.Equal(t, tt.expectedReason, apiErr.Error.Code)
+			}
+			err = res.Body.Close()
 			require.NoError(t, err)
 		})
 	}
+
+	rec, err := repo.Get(context.Background(), "Stly84Xz-d8=")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMovedPermanently, rec.RedirectCode)
+}
+
+func TestHandler_APIPrepareBatchShortURL(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	post := func(body string) (int, []models.APIResponsePrepareBatchShURL) {
+		r := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.APIPrepareBatchShortURL(w, r)
+		var resp []models.APIResponsePrepareBatchShURL
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return w.Code, resp
+	}
+
+	code, resp := post(`[{"correlation_id":"1","original_url":"https://vk.com"}]`)
+	require.Equal(t, http.StatusCreated, code)
+	require.Len(t, resp, 1)
+	assert.Equal(t, "created", resp[0].Status)
+	assert.Equal(t, "http://localhost:8000/ymMooIzfwh4=", resp[0].ShortURL)
+
+	code, resp = post(`[
+		{"correlation_id":"1","original_url":"https://vk.com"},
+		{"correlation_id":"2","original_url":"not url"},
+		{"correlation_id":"3","original_url":"https://ya.ru"}
+	]`)
+	require.Equal(t, http.StatusMultiStatus, code)
+	require.Len(t, resp, 3)
+	assert.Equal(t, models.APIResponsePrepareBatchShURL{CorrelationID: "1", ShortURL: "http://localhost:8000/ymMooIzfwh4=", Status: "existing"}, resp[0])
+	assert.Equal(t, "2", resp[1].CorrelationID)
+	assert.Equal(t, "invalid", resp[1].Status)
+	assert.Empty(t, resp[1].ShortURL)
+	assert.NotEmpty(t, resp[1].Error)
+	assert.Equal(t, "created", resp[2].Status)
+}
+
+func TestHandler_APIPrepareStreamShortURL(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	post := func(body string) []string {
+		r := httptest.NewRequest(http.MethodPost, "/api/shorten/stream", strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		handler.APIPrepareStreamShortURL(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		return strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	}
+
+	lines := post(`{"correlation_id":"1","original_url":"https://vk.com"}
+
+{"correlation_id":"2","original_url":"not url"}
+{"correlation_id":
+{"correlation_id":"4","original_url":"https://vk.com"}
+`)
+	require.Len(t, lines, 4)
+	var got []models.APIResponseStreamShURL
+	for _, line := range lines {
+		var item models.APIResponseStreamShURL
+		require.NoError(t, json.Unmarshal([]byte(line), &item))
+		got = append(got, item)
+	}
+	assert.Equal(t, models.APIResponseStreamShURL{Line: 1, APIResponsePrepareBatchShURL: models.APIResponsePrepareBatchShURL{
+		CorrelationID: "1", ShortURL: "http://localhost:8000/ymMooIzfwh4=", Status: "created",
+	}}, got[0])
+	assert.Equal(t, 3, got[1].Line)
+	assert.Equal(t, "invalid", got[1].Status)
+	assert.Equal(t, 4, got[2].Line)
+	assert.Equal(t, "invalid", got[2].Status)
+	assert.Contains(t, got[2].Error, "failed to decode body")
+	assert.Equal(t, "existing", got[3].Status)
+
+	// больше одной порции: каждая строка получает ответ, порядок сохраняется
+	var body strings.Builder
+	total := 2*streamChunkSize + 1
+	for i := 1; i <= total; i++ {
+		fmt.Fprintf(&body, `{"correlation_id":"%d","original_url":"https://example.com/%d"}`+"\n", i, i)
+	}
+	lines = post(body.String())
+	require.Len(t, lines, total)
+	var last models.APIResponseStreamShURL
+	require.NoError(t, json.Unmarshal([]byte(lines[total-1]), &last))
+	assert.Equal(t, total, last.Line)
+	assert.Equal(t, strconv.Itoa(total), last.CorrelationID)
+	assert.Equal(t, "created", last.Status)
+
+	// слишком длинная строка обрывает поток конвертом ошибки
+	lines = post(`{"correlation_id":"1","original_url":"https://ok.ru"}` + "\n" + strings.Repeat("x", streamMaxLineSize+1) + "\n")
+	require.Len(t, lines, 2)
+	var apiErr models.APIError
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &apiErr))
+	assert.Equal(t, "invalid_body", apiErr.Error.Code)
+}
+
+func TestHandler_ImportURLs(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	post := func(body string) []models.APIResponseImportRow {
+		r := httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(body))
+		r.Header.Add("Content-Type", "text/csv")
+		r = r.WithContext(middleware.WithUserID(r.Context(), "user"))
+		w := httptest.NewRecorder()
+		handler.ImportURLs(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		var rows []models.APIResponseImportRow
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+			var row models.APIResponseImportRow
+			require.NoError(t, json.Unmarshal([]byte(line), &row))
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	// свой формат с заголовком
+	rows := post("\ufefforiginal_url,alias,expires_at,tags\n" +
+		"https://vk.com,,,\n" +
+		"https://ok.ru,my-ok,2099-01-02,social\n" +
+		"not url,,,\n" +
+		"https://ya.ru,,tomorrow,\n")
+	require.Len(t, rows, 4)
+	assert.Equal(t, models.APIResponseImportRow{Line: 2, OriginalURL: "https://vk.com", ShortURL: "http://localhost:8000/ymMooIzfwh4=", Status: "created"}, rows[0])
+	assert.Equal(t, "http://localhost:8000/my-ok", rows[1].ShortURL)
+	assert.Equal(t, "created", rows[1].Status)
+	assert.Equal(t, "invalid", rows[2].Status)
+	assert.Equal(t, 5, rows[3].Line)
+	assert.Equal(t, "invalid", rows[3].Status)
+	link, err := repo.Get(context.Background(), "my-ok")
+	require.NoError(t, err)
+	require.NotNil(t, link.ExpiresAt)
+	assert.Equal(t, 2099, link.ExpiresAt.Year())
+	assert.Equal(t, repository.Tags{"social"}, link.Tags)
+
+	// выгрузка другого сокращателя: путь короткой ссылки становится алиасом
+	rows = post("Bitlink,Long URL,Title\n" +
+		"https://bit.ly/promo-2024?utm=1,https://example.com/promo,Promo\n" +
+		"\"https://bit.ly/bad,https://example.com\n")
+	require.Len(t, rows, 2)
+	assert.Equal(t, "http://localhost:8000/promo-2024", rows[0].ShortURL)
+	assert.Equal(t, "created", rows[0].Status)
+	assert.Equal(t, "invalid", rows[1].Status)
+	assert.Contains(t, rows[1].Error, "failed to decode body")
+
+	// без заголовка, повторный импорт не плодит дубли
+	rows = post("https://vk.com\nhttps://ok.ru,my-ok\n")
+	require.Len(t, rows, 2)
+	assert.Equal(t, 1, rows[0].Line)
+	assert.Equal(t, "existing", rows[0].Status)
+	assert.Equal(t, "existing", rows[1].Status)
+}
+
+func TestHandler_ExportUserURLs(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2099, 1, 2, 0, 0, 0, 0, time.UTC)
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "ymMooIzfwh4=", OriginalURL: "https://vk.com", UserID: "user", CreatedAt: createdAt})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{
+		ShortURL: "my-ok", OriginalURL: "https://ok.ru", UserID: "user", CreatedAt: createdAt.Add(time.Hour), ExpiresAt: &expiresAt,
+		Title: "OK", Tags: []string{"social", "work"},
+	})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	tests := []struct {
+		name         string
+		userID       string
+		format       string
+		expectedCode int
+		expectedType string
+		expectedBody string
+	}{
+		{
+			name:         "CSV by default",
+			userID:       "user",
+			expectedCode: http.StatusOK,
+			expectedType: "text/csv; charset=utf-8",
+			expectedBody: "original_url,alias,expires_at,tags,title,short_url,created_at\n" +
+				"https://vk.com,,,,,http://localhost:8000/ymMooIzfwh4=,2024-05-01T10:00:00Z\n" +
+				"https://ok.ru,my-ok,2099-01-02T00:00:00Z,\"social,work\",OK,http://localhost:8000/my-ok,2024-05-01T11:00:00Z\n",
+		},
+		{
+			name:         "JSON",
+			userID:       "user",
+			format:       "json",
+			expectedCode: http.StatusOK,
+			expectedType: "application/json",
+			expectedBody: `[{"short_url":"http://localhost:8000/ymMooIzfwh4=","original_url":"https://vk.com","created_at":"2024-05-01T10:00:00Z"},` +
+				`{"short_url":"http://localhost:8000/my-ok","original_url":"https://ok.ru","alias":"my-ok","title":"OK","tags":["social","work"],` +
+				`"created_at":"2024-05-01T11:00:00Z","expires_at":"2099-01-02T00:00:00Z"}]`,
+		},
+		{
+			name:         "Unknown format",
+			userID:       "user",
+			format:       "xml",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "No user",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/export?format="+tt.format, nil)
+			r = r.WithContext(middleware.WithUserID(r.Context(), tt.userID))
+			w := httptest.NewRecorder()
+			handler.ExportUserURLs(w, r)
+
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedType == "application/json" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			} else if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+			}
+			if tt.expectedType != "" {
+				assert.Equal(t, tt.expectedType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHandler_ExportUserURLs_Pages(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	repo := repository.NewInMemoryRepository()
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	total := 2*exportPageSize + 7
+	for i := 0; i < total; i++ {
+		_, _ = repo.Save(context.Background(), repository.URLRecord{
+			ShortURL: "link-" + strconv.Itoa(i), OriginalURL: "https://example.com/" + strconv.Itoa(i),
+			UserID: "user", CreatedAt: createdAt.Add(time.Duration(i) * time.Second),
+		})
+	}
+	handler := New(services.New(repo, cfg))
+
+	// выгрузка проходит все страницы, каждая ссылка ровно один раз
+	for _, format := range []string{"csv", "json"} {
+		t.Run(format, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/export?format="+format+"&limit=1", nil)
+			r = r.WithContext(middleware.WithUserID(r.Context(), "user"))
+			w := httptest.NewRecorder()
+			handler.ExportUserURLs(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var originals []string
+			if format == "json" {
+				var items []models.APIResponseExportURL
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+				for _, item := range items {
+					originals = append(originals, item.OriginalURL)
+				}
+			} else {
+				records, err := csv.NewReader(w.Body).ReadAll()
+				require.NoError(t, err)
+				for _, record := range records[1:] {
+					originals = append(originals, record[0])
+				}
+			}
+			require.Len(t, originals, total)
+			seen := make(map[string]bool)
+			for _, original := range originals {
+				assert.False(t, seen[original], original)
+				seen[original] = true
+			}
+		})
+	}
+}
+
+func TestHandler_ExportImportRoundTrip(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	src := repository.NewInMemoryRepository()
+	_, _ = src.Save(context.Background(), repository.URLRecord{ShortURL: "ymMooIzfwh4=", OriginalURL: "https://vk.com", UserID: "user", Title: `=HYPERLINK("https://evil.example")`})
+	_, _ = src.Save(context.Background(), repository.URLRecord{ShortURL: "my-ok", OriginalURL: "https://ok.ru", UserID: "user", Tags: []string{"-work"}})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/export", nil)
+	r = r.WithContext(middleware.WithUserID(r.Context(), "user"))
+	w := httptest.NewRecorder()
+	New(services.New(src, cfg)).ExportUserURLs(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	exported := w.Body.String()
+	// ячейки, похожие на формулы, экранированы
+	assert.Contains(t, exported, `"'=HYPERLINK(""https://evil.example"")"`)
+	assert.Contains(t, exported, `,'-work,`)
+
+	dst := repository.NewInMemoryRepository()
+	r = httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(exported))
+	r = r.WithContext(middleware.WithUserID(r.Context(), "user"))
+	w = httptest.NewRecorder()
+	New(services.New(dst, cfg)).ImportURLs(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var rows []models.APIResponseImportRow
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var row models.APIResponseImportRow
+		require.NoError(t, json.Unmarshal([]byte(line), &row))
+		rows = append(rows, row)
+	}
+	require.Len(t, rows, 2)
+	for _, row := range rows {
+		assert.Equal(t, "created", row.Status, row.Error)
+	}
+	hashed, err := dst.Get(context.Background(), "ymMooIzfwh4=")
+	require.NoError(t, err)
+	assert.Equal(t, `=HYPERLINK("https://evil.example")`, hashed.Title)
+	aliased, err := dst.Get(context.Background(), "my-ok")
+	require.NoError(t, err)
+	assert.Equal(t, repository.Tags{"-work"}, aliased.Tags)
+}
+
+func TestHandler_ExpandShortURL(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "ymMooIzfwh4=", OriginalURL: "https://vk.com", RedirectCode: http.StatusMovedPermanently})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "secret", OriginalURL: "https://ok.ru", PasswordHash: "hash"})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	tests := []struct {
+		name         string
+		short        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "By id",
+			short:        "ymMooIzfwh4=",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"short_url":"http://localhost:8000/ymMooIzfwh4=","original_url":"https://vk.com","redirect_code":301}`,
+		},
+		{
+			name:         "By short url",
+			short:        "http://localhost:8000/ymMooIzfwh4=",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"short_url":"http://localhost:8000/ymMooIzfwh4=","original_url":"https://vk.com","redirect_code":301}`,
+		},
+		{
+			name:         "Foreign short url",
+			short:        "https://bit.ly/ymMooIzfwh4=",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":{"code":"invalid_short_url","message":"short url \"https://bit.ly/ymMooIzfwh4=\" does not belong to http://localhost:8000: invalid short url"}}`,
+		},
+		{
+			name:         "Not found",
+			short:        "nothing",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Protected",
+			short:        "secret",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":{"code":"password_required","message":"url is protected by password"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/expand?short="+url.QueryEscape(tt.short), nil)
+			w := httptest.NewRecorder()
+			handler.ExpandShortURL(w, r)
+
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ExpandBatch(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "ymMooIzfwh4=", OriginalURL: "https://vk.com"})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	body := `["http://localhost:8000/ymMooIzfwh4=","nothing","a/b"]`
+	r := httptest.NewRequest(http.MethodPost, "/api/expand/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ExpandBatch(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var got []models.APIResponseExpandBatchItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	require.Len(t, got, 3)
+	assert.Equal(t, "found", got[0].Status)
+	assert.Equal(t, "https://vk.com", got[0].OriginalURL)
+	assert.Equal(t, "http://localhost:8000/ymMooIzfwh4=", got[0].Short)
+	assert.Equal(t, "not_found", got[1].Status)
+	assert.Empty(t, got[1].OriginalURL)
+	assert.Equal(t, "invalid_short_url", got[2].Status)
+
+	r = httptest.NewRequest(http.MethodPost, "/api/expand/batch", strings.NewReader(`{"short":"x"}`))
+	w = httptest.NewRecorder()
+	handler.ExpandBatch(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandler_GetUserURLs(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "_SGMGLQIsIM=", OriginalURL: "http://mbrgaoyhv.yandex", UserID: "user"})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "ymMooIzfwh4=", OriginalURL: "https://vk.com", UserID: "other"})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	tests := []struct {
+		name         string
+		userID       string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "User has urls",
+			userID:       "user",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"short_url":"http://localhost:8000/_SGMGLQIsIM=","original_url":"http://mbrgaoyhv.yandex"}]`,
+		},
+		{
+			name:         "User has no urls",
+			userID:       "newbie",
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "No user",
+			userID:       "",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			r = r.WithContext(middleware.WithUserID(r.Context(), tt.userID))
+			w := httptest.NewRecorder()
+			handler.GetUserURLs(w, r)
+
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_GetUserURLs_Query(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	links := []repository.URLRecord{
+		{ShortURL: "a", OriginalURL: "https://github.com/org/repo", Title: "Repo", Tags: []string{"code", "work"}},
+		{ShortURL: "b", OriginalURL: "https://vk.com", Title: "Social", Tags: []string{"social"}},
+		{ShortURL: "c", OriginalURL: "https://docs.example.com", Title: "Docs", Tags: []string{"work"}},
+		{ShortURL: "d", OriginalURL: "https://gitlab.com/org/repo", Title: "Mirror", Tags: []string{"code"}},
+	}
+	for i, rec := range links {
+		rec.UserID = "user"
+		rec.CreatedAt = createdAt.Add(time.Duration(i) * time.Minute)
+		_, _ = repo.Save(context.Background(), rec)
+	}
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	get := func(t *testing.T, query string) (*httptest.ResponseRecorder, []string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/user/urls?"+query, nil)
+		r = r.WithContext(middleware.WithUserID(r.Context(), "user"))
+		w := httptest.NewRecorder()
+		handler.GetUserURLs(w, r)
+		var got []models.APIResponseUserURL
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		}
+		shorts := make([]string, 0, len(got))
+		for _, item := range got {
+			shorts = append(shorts, strings.TrimPrefix(item.ShortURL, "http://localhost:8000/"))
+		}
+		return w, shorts
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"Default order", "", []string{"a", "b", "c", "d"}},
+		{"One tag", "tag=work", []string{"a", "c"}},
+		{"All tags", "tag=work&tag=CODE", []string{"a"}},
+		{"Tags separated by comma", "tag=code,work", []string{"a"}},
+		{"Search", "q=REPO", []string{"a", "d"}},
+		{"Search and tag", "q=git&tag=code", []string{"a", "d"}},
+		{"Sort by title", "sort=title", []string{"c", "d", "a", "b"}},
+		{"Sort descending", "sort=-created_at", []string{"d", "c", "b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, shorts := get(t, tt.query)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expected, shorts)
+			assert.Empty(t, w.Header().Get(NextCursorHeader))
+		})
+	}
+
+	t.Run("Pagination", func(t *testing.T) {
+		var pages [][]string
+		query := "sort=-title&limit=3"
+		for {
+			w, shorts := get(t, query)
+			require.Equal(t, http.StatusOK, w.Code)
+			pages = append(pages, shorts)
+			next := w.Header().Get(NextCursorHeader)
+			if next == "" {
+				break
+			}
+			query = "sort=-title&limit=3&cursor=" + next
+		}
+		assert.Equal(t, [][]string{{"b", "a", "d"}, {"c"}}, pages)
+	})
+
+	t.Run("Bad query", func(t *testing.T) {
+		w, _ := get(t, "sort=title&limit=1")
+		cursor := w.Header().Get(NextCursorHeader)
+		require.NotEmpty(t, cursor)
+		for _, query := range []string{"sort=-title&cursor=" + cursor, "cursor=garbage", "limit=0", "sort=clicks"} {
+			w, _ = get(t, query)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.Contains(t, w.Body.String(), `"invalid_query"`, query)
+		}
+	})
+}
+
+func TestHandler_DeleteUserURLs(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8000"}
+	err := logger.InitLogger(cfg.Environment)
+	require.NoError(t, err)
+	repo := repository.NewInMemoryRepository()
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "_SGMGLQIsIM=", OriginalURL: "http://mbrgaoyhv.yandex", UserID: "user"})
+	_, _ = repo.Save(context.Background(), repository.URLRecord{ShortURL: "ymMooIzfwh4=", OriginalURL: "https://vk.com", UserID: "other"})
+	service := services.New(repo, cfg)
+	handler := New(service)
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["_SGMGLQIsIM=","ymMooIzfwh4="]`))
+	r = r.WithContext(middleware.WithUserID(r.Context(), "user"))
+	w := httptest.NewRecorder()
+	handler.DeleteUserURLs(w, r)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	// дожидаемся фонового удаления
+	service.Close()
+
+	tests := []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{name: "Own url is deleted", id: "_SGMGLQIsIM=", expectedCode: http.StatusGone},
+		{name: "Foreign url is kept", id: "ymMooIzfwh4=", expectedCode: http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.id, nil)
+			rc := chi.NewRouteContext()
+			rc.URLParams.Add("id", tt.id)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+			w := httptest.NewRecorder()
+			handler.GetLongURL(w, r)
+
+			require.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
 }
 
 func TestHandler_PingDB(t *testing.T) {
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type importField int
+
+const (
+	importSkip importField = iota
+	importURL
+	importAlias
+	importShortLink
+	importExpiresAt
+	importTags
+	importTitle
+)
+
+// importHeaders - названия колонок в нашей выгрузке и в выгрузках других сокращателей
+var importHeaders = map[string]importField{
+	"original_url":    importURL,
+	"long_url":        importURL,
+	"long url":        importURL,
+	"url":             importURL,
+	"destination":     importURL,
+	"destination url": importURL,
+	"alias":           importAlias,
+	"custom alias":    importAlias,
+	"keyword":         importAlias,
+	"slug":            importAlias,
+	"back-half":       importAlias,
+	"short_url":       importShortLink,
+	"short url":       importShortLink,
+	"shorturl":        importShortLink,
+	"link":            importShortLink,
+	"bitlink":         importShortLink,
+	"expires_at":      importExpiresAt,
+	"expires":         importExpiresAt,
+	"expiration":      importExpiresAt,
+	"expiration date": importExpiresAt,
+	"tags":            importTags,
+	"tag":             importTags,
+	"title":           importTitle,
+	"name":            importTitle,
+}
+
+// importDefaultLayout - порядок колонок, если в файле нет заголовка
+var importDefaultLayout = []importField{importURL, importAlias, importExpiresAt, importTags, importTitle}
+
+var importTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseImportHeader возвращает раскладку колонок, если record похож на заголовок
+func parseImportHeader(record []string) ([]importField, bool) {
+	layout := make([]importField, len(record))
+	hasURL := false
+	for i, name := range record {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		layout[i] = importHeaders[name]
+		hasURL = hasURL || layout[i] == importURL
+	}
+	return layout, hasURL
+}
+
+// shortLinkAlias достает id из короткой ссылки другого сервиса, чтобы старые ссылки сохранили путь.
+// Наши ссылки алиас не дают: пользовательские id выгружаются в колонке alias,
+// а хеш при повторном импорте получится тот же
+func (h *Handler) shortLinkAlias(link string) string {
+	link = strings.TrimSpace(link)
+	if strings.Contains(link, "://") {
+		if _, err := h.service.ParseShortID(link); err == nil {
+			return ""
+		}
+	}
+	link, _, _ = strings.Cut(link, "?")
+	link = strings.TrimRight(link, "/")
+	i := strings.LastIndex(link, "/")
+	if i < 0 || !services.IsAlias(link[i+1:]) {
+		return ""
+	}
+	return link[i+1:]
+}
+
+// unescapeCSVCell снимает защиту от формул, которую ставит csvSafe при выгрузке
+func unescapeCSVCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+// isTagSeparator - в выгрузках других сервисов теги разделяют и запятой, и точкой с запятой
+func isTagSeparator(r rune) bool {
+	return r == ',' || r == ';' || r == '|'
+}
+
+func parseImportTime(raw string) (*time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("can not parse expiration %q: %w", raw, services.ErrInvalidExpiry)
+}
+
+// importEntry собирает элемент пакета из строки CSV
+func (h *Handler) importEntry(layout []importField, record []string, userID string, anonymous bool) services.BatchEntry {
+	var (
+		longURL, alias, shortLink, expires string
+		params                             services.LinkParams
+		entry                              services.BatchEntry
+	)
+	for i, value := range record {
+		if i >= len(layout) {
+			break
+		}
+		value = unescapeCSVCell(strings.TrimSpace(value))
+		switch layout[i] {
+		case importURL:
+			longURL = value
+		case importAlias:
+			alias = value
+		case importShortLink:
+			shortLink = value
+		case importExpiresAt:
+			expires = value
+		case importTags:
+			params.Tags = strings.FieldsFunc(value, isTagSeparator)
+		case importTitle:
+			params.Title = value
+		}
+	}
+	if alias == "" && shortLink != "" {
+		alias = h.shortLinkAlias(shortLink)
+	}
+
+	if expires != "" {
+		expiresAt, err := parseImportTime(expires)
+		if err != nil {
+			entry.LongURL = longURL
+			entry.Invalidate(err)
+			return entry
+		}
+		params.ExpiresAt = expiresAt
+	}
+	entry = h.service.NewBatchEntry(longURL, userID, anonymous, params)
+	if entry.Err == nil {
+		entry.Alias = alias
+	}
+	return entry
+}
+
+// ImportURLs принимает CSV: свой формат original_url,alias,expires_at,tags,title или выгрузку
+// другого сокращателя с заголовком. Сохраняет теми же порциями, что и NDJSON-импорт
+func (h *Handler) ImportURLs(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got ImportURLs request")
+	userID, anonymous := userIDFromReq(req), middleware.IsAnonymous(req.Context())
+	w := h.newStreamWriter(res, req, importResult)
+
+	reader := csv.NewReader(&lineLimitReader{r: req.Body, limit: streamMaxLineSize})
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	var layout []importField
+	rows := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// после ошибки разбора csv.Reader продолжает со следующей строки
+			var entry services.BatchEntry
+			entry.Invalidate(fmt.Errorf("%w: %v", ErrDecodeBody, parseErr.Err))
+			if err := w.add(parseErr.StartLine, "", entry); err != nil {
+				w.fail(err)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			// уже прочитанные строки сохраняем, даже если дальше тело оборвалось
+			if flushErr := w.flush(); flushErr != nil {
+				w.fail(flushErr)
+				return
+			}
+			w.fail(fmt.Errorf("%w: %v", ErrReadingBody, err))
+			return
+		}
+		line, _ := reader.FieldPos(0)
+		if layout == nil {
+			var isHeader bool
+			if layout, isHeader = parseImportHeader(record); isHeader {
+				continue
+			}
+			layout = importDefaultLayout
+		}
+		rows++
+		if err := w.add(line, "", h.importEntry(layout, record, userID, anonymous)); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	if err := w.flush(); err != nil {
+		w.fail(err)
+		return
+	}
+	logger.Sugaarz.Debugw("sent ImportURLs response", "rows", rows)
+}
+
+func importResult(line int, _ string, entry services.BatchEntry) any {
+	item := models.APIResponseImportRow{
+		Line:        line,
+		OriginalURL: entry.LongURL,
+		ShortURL:    entry.ShortURL,
+		Status:      string(entry.Status),
+	}
+	if entry.Err != nil {
+		item.Error = entry.Err.Error()
+	}
+	return item
+}
+
+// lineLimitReader обрывает чтение на слишком длинной строке, иначе одно поле CSV может занять всю память
+type lineLimitReader struct {
+	r       io.Reader
+	limit   int
+	current int
+}
+
+var errLineTooLong = errors.New("line is too long")
+
+func (l *lineLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == '\n' {
+			l.current = 0
+			continue
+		}
+		l.current++
+		if l.current > l.limit {
+			return i, errLineTooLong
+		}
+	}
+	return n, err
+}
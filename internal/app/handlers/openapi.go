@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/stlesnik/url_shortener/internal/app/openapi"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net/http"
+)
+
+func (h *Handler) GetOpenAPISpec(res http.ResponseWriter, _ *http.Request) {
+	logger.Sugaarz.Debugw("got GetOpenAPISpec request")
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, _ = res.Write(openapi.Spec)
+}
+
+func (h *Handler) GetAPIDocs(res http.ResponseWriter, _ *http.Request) {
+	logger.Sugaarz.Debugw("got GetAPIDocs request")
+	page, err := openapi.DocsPage()
+	if err != nil {
+		logger.Sugaarz.Errorw("error rendering docs page", "err", err)
+		WriteError(res, "Failed to render docs", http.StatusInternalServerError, true)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// страница статическая, скрипты ей не нужны вовсе
+	res.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	res.WriteHeader(http.StatusOK)
+	_, _ = res.Write(page)
+}
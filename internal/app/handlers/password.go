@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PasswordHeader позволяет передать пароль ссылки без формы, например из curl
+	PasswordHeader    = "X-Link-Password"
+	passwordFormField = "password"
+)
+
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<h1>This link is protected by a password</h1>
+{{if .}}<p>{{.}}</p>{{end}}
+<form method="post">
+<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// unlockLink пропускает запрос к защищенной ссылке, только если пароль подошел;
+// в остальных случаях сам отвечает клиенту и возвращает false
+func (h *Handler) unlockLink(res http.ResponseWriter, req *http.Request, rec repository.URLRecord) bool {
+	password := req.Header.Get(PasswordHeader)
+	if password == "" && req.Method == http.MethodPost {
+		password = req.PostFormValue(passwordFormField)
+	}
+	err := h.service.UnlockLink(rec, password, middleware.ClientIP(req))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrPasswordRequired):
+		writePasswordPrompt(res, req, "")
+	case errors.Is(err, services.ErrWrongPassword):
+		writePasswordPrompt(res, req, "Wrong password, try again.")
+	case errors.Is(err, services.ErrTooManyAttempts):
+		res.Header().Set("Retry-After", strconv.Itoa(int(services.PasswordFailureWindow.Seconds())))
+		WriteError(res, "Too many failed password attempts, try again later", http.StatusTooManyRequests, false)
+	default:
+		logger.Sugaarz.Errorw("error while checking link password", "err", err)
+		WriteError(res, "Failed to check password", http.StatusInternalServerError, true)
+	}
+	return false
+}
+
+// writePasswordPrompt показывает браузеру форму, а остальным клиентам - короткий текст
+func writePasswordPrompt(res http.ResponseWriter, req *http.Request, message string) {
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		text := "Password required"
+		if message != "" {
+			text = message
+		}
+		WriteError(res, text, http.StatusUnauthorized, false)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusUnauthorized)
+	if err := passwordPage.Execute(res, message); err != nil {
+		logger.Sugaarz.Errorw("error rendering password page", "err", err)
+	}
+}
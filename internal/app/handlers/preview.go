@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// previewSuffix после id просит показать, куда ведет ссылка, вместо редиректа
+const previewSuffix = "+"
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+</head>
+<body>
+<h1>This link leads to</h1>
+<p><a href="{{.OriginalURL}}" rel="noopener noreferrer">{{.OriginalURL}}</a></p>
+<p>Short link: {{.ShortURL}}</p>
+{{if not .CreatedAt.IsZero}}<p>Created: {{.CreatedAt.Format "2006-01-02 15:04 MST"}}</p>{{end}}
+{{with .ExpiresAt}}<p>Expires: {{.Format "2006-01-02 15:04 MST"}}</p>{{end}}
+<form method="get" action="{{.ShortURL}}"><button type="submit">Continue</button></form>
+</body>
+</html>
+`))
+
+// previewRequested разбирает id и признак предпросмотра: суффикс "+" или ?preview=1
+func previewRequested(req *http.Request) (string, bool) {
+	URLHash := chi.URLParam(req, "id")
+	if trimmed, found := strings.CutSuffix(URLHash, previewSuffix); found {
+		return trimmed, true
+	}
+	switch req.URL.Query().Get("preview") {
+	case "1", "true":
+		return URLHash, true
+	}
+	return URLHash, false
+}
+
+// writePreview отдает страницу предпросмотра, а при Accept: application/json - те же данные в json
+func (h *Handler) writePreview(res http.ResponseWriter, req *http.Request, rec repository.URLRecord) {
+	logger.Sugaarz.Debugw("got link preview request")
+	apiResp := models.APIResponseLinkPreview{
+		ShortURL:    h.service.PrepareShortURL(rec.ShortURL),
+		OriginalURL: rec.OriginalURL,
+		CreatedAt:   rec.CreatedAt,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+	res.Header().Set("Vary", "Accept")
+	res.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(req.Header.Get("Accept"), "application/json") {
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(res).Encode(apiResp); err != nil {
+			logger.Sugaarz.Errorw("error encoding body", "err", err)
+			return
+		}
+		logger.Sugaarz.Debugw("sent link preview response")
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	if err := previewPage.Execute(res, apiResp); err != nil {
+		logger.Sugaarz.Errorw("error rendering preview page", "err", err)
+		return
+	}
+	logger.Sugaarz.Debugw("sent link preview response")
+}
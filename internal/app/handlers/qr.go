@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"github.com/stlesnik/url_shortener/internal/qrcode"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	qrFormatPNG = "png"
+	qrFormatSVG = "svg"
+
+	qrDefaultSize = 256
+	qrMinSize     = 64
+	qrMaxSize     = 2048
+)
+
+// qrFormat берет формат из ?format=, а без него - из заголовка Accept; по умолчанию PNG
+func qrFormat(req *http.Request) (string, error) {
+	switch format := strings.ToLower(req.URL.Query().Get("format")); format {
+	case qrFormatPNG, qrFormatSVG:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown qr format %q", format)
+	}
+	if strings.Contains(req.Header.Get("Accept"), "image/svg+xml") {
+		return qrFormatSVG, nil
+	}
+	return qrFormatPNG, nil
+}
+
+func qrSize(req *http.Request) (int, error) {
+	raw := req.URL.Query().Get("size")
+	if raw == "" {
+		return qrDefaultSize, nil
+	}
+	size, err := strconv.Atoi(raw)
+	if err != nil || size < qrMinSize || size > qrMaxSize {
+		return 0, fmt.Errorf("size must be an integer between %d and %d", qrMinSize, qrMaxSize)
+	}
+	return size, nil
+}
+
+func qrLevel(req *http.Request) (qrcode.Level, error) {
+	raw := req.URL.Query().Get("ec")
+	if raw == "" {
+		return qrcode.Medium, nil
+	}
+	return qrcode.ParseLevel(raw)
+}
+
+func (h *Handler) GetQRCode(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got GetQRCode request")
+	URLHash := chi.URLParam(req, "id")
+	format, err := qrFormat(req)
+	if err != nil {
+		WriteError(res, err.Error(), http.StatusBadRequest, false)
+		return
+	}
+	size, err := qrSize(req)
+	if err != nil {
+		WriteError(res, err.Error(), http.StatusBadRequest, false)
+		return
+	}
+	level, err := qrLevel(req)
+	if err != nil {
+		WriteError(res, err.Error(), http.StatusBadRequest, false)
+		return
+	}
+
+	code, err := h.service.QRCode(req.Context(), URLHash, level)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrURLNotFound):
+		WriteError(res, "Short url not found", http.StatusNotFound, false)
+		return
+	case errors.Is(err, repository.ErrURLDeleted):
+		WriteError(res, "Short url is deleted", http.StatusGone, false)
+		return
+	case errors.Is(err, services.ErrURLExpired):
+		WriteError(res, "Short url is expired", http.StatusGone, false)
+		return
+	default:
+		logger.Sugaarz.Errorw("error while encoding qr code", "err", err)
+		WriteError(res, "Failed to encode qr code", http.StatusInternalServerError, true)
+		return
+	}
+
+	var body []byte
+	if format == qrFormatSVG {
+		res.Header().Set("Content-Type", "image/svg+xml")
+		body = code.SVG(size)
+	} else {
+		body, err = code.PNG(size)
+		if err != nil {
+			WriteError(res, "Size is too small for this qr code: "+err.Error(), http.StatusBadRequest, false)
+			return
+		}
+		res.Header().Set("Content-Type", "image/png")
+	}
+	res.Header().Set("Vary", "Accept")
+	res.WriteHeader(http.StatusOK)
+	if _, err := res.Write(body); err != nil {
+		logger.Sugaarz.Errorw("error writing qr code", "err", err)
+		return
+	}
+	logger.Sugaarz.Debugw("sent GetQRCode response")
+}
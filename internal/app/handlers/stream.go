@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/middleware"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net/http"
+)
+
+const (
+	// streamChunkSize ограничивает размер одной транзакции и объем непрочитанного ответа в памяти
+	streamChunkSize = 500
+	// streamMaxLineSize - строка длиннее обрывает поток: продолжить разбор после нее нельзя
+	streamMaxLineSize = 64 * 1024
+)
+
+// streamRow собирает строку ответа по сохраненному элементу порции
+type streamRow func(line int, key string, entry services.BatchEntry) any
+
+// streamWriter копит элементы в порции, сохраняет их и сразу отдает клиенту результат.
+// Новые элементы добавляются только после того, как ответ на предыдущую порцию ушел,
+// поэтому медленный клиент притормаживает загрузку, а память не растет с размером тела
+type streamWriter struct {
+	h       *Handler
+	req     *http.Request
+	enc     *json.Encoder
+	rc      *http.ResponseController
+	row     streamRow
+	entries []services.BatchEntry
+	lines   []int
+	keys    []string
+}
+
+// newStreamWriter отправляет заголовки ответа, после этого статус уже не поменять
+func (h *Handler) newStreamWriter(res http.ResponseWriter, req *http.Request, row streamRow) *streamWriter {
+	rc := http.NewResponseController(res)
+	// без этого HTTP/1.1 сервер перестает отдавать тело запроса, как только начат ответ
+	if err := rc.EnableFullDuplex(); err != nil {
+		logger.Sugaarz.Debugw("full duplex is not supported", "err", err)
+	}
+	res.Header().Set("Content-Type", "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+	return &streamWriter{h: h, req: req, enc: json.NewEncoder(res), rc: rc, row: row}
+}
+
+// add ставит элемент в порцию и сохраняет ее, когда она заполнилась. key - например, correlation_id
+func (w *streamWriter) add(line int, key string, entry services.BatchEntry) error {
+	w.entries = append(w.entries, entry)
+	w.lines = append(w.lines, line)
+	w.keys = append(w.keys, key)
+	if len(w.entries) < streamChunkSize {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *streamWriter) flush() error {
+	if len(w.entries) == 0 {
+		return nil
+	}
+	if err := w.h.service.SaveBatchShortURL(w.req.Context(), w.entries); err != nil {
+		return fmt.Errorf("error while saving stream chunk: %w", err)
+	}
+	for i, entry := range w.entries {
+		if err := w.enc.Encode(w.row(w.lines[i], w.keys[i], entry)); err != nil {
+			return err
+		}
+	}
+	if err := w.rc.Flush(); err != nil {
+		logger.Sugaarz.Debugw("failed to flush stream", "err", err)
+	}
+	w.entries, w.lines, w.keys = w.entries[:0], w.lines[:0], w.keys[:0]
+	return nil
+}
+
+// fail завершает поток строкой с конвертом ошибки: статус ответа уже отправлен
+func (w *streamWriter) fail(err error) {
+	_, apiErr := newAPIError(w.req, err)
+	_ = w.enc.Encode(apiErr)
+}
+
+// APIPrepareStreamShortURL принимает NDJSON и отвечает NDJSON по мере сохранения порций
+func (h *Handler) APIPrepareStreamShortURL(res http.ResponseWriter, req *http.Request) {
+	logger.Sugaarz.Debugw("got APIPrepareStreamShortURL request")
+	userID, anonymous := userIDFromReq(req), middleware.IsAnonymous(req.Context())
+	w := h.newStreamWriter(res, req, streamResult)
+
+	scanner := bufio.NewScanner(req.Body)
+	scanner.Buffer(make([]byte, 0, 4096), streamMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var obj models.APIRequestPrepareBatchShURL
+		var entry services.BatchEntry
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			entry.Invalidate(fmt.Errorf("%w: %v", ErrDecodeBody, err))
+		} else {
+			entry = h.service.NewBatchEntry(obj.LongURL, userID, anonymous, linkParams(obj.LinkOptions))
+		}
+		if err := w.add(line, obj.CorrelationID, entry); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	// уже прочитанные строки сохраняем, даже если дальше тело оборвалось
+	if err := w.flush(); err != nil {
+		w.fail(err)
+		return
+	}
+	if err := scanner.Err(); err != nil {
+		w.fail(fmt.Errorf("%w: line %d: %v", ErrReadingBody, line+1, err))
+		return
+	}
+	logger.Sugaarz.Debugw("sent APIPrepareStreamShortURL response", "lines", line)
+}
+
+func streamResult(line int, correlationID string, entry services.BatchEntry) any {
+	item := models.APIResponseStreamShURL{
+		Line: line,
+		APIResponsePrepareBatchShURL: models.APIResponsePrepareBatchShURL{
+			CorrelationID: correlationID,
+			ShortURL:      entry.ShortURL,
+			Status:        string(entry.Status),
+		},
+	}
+	if entry.Err != nil {
+		item.Error = entry.Err.Error()
+	}
+	return item
+}
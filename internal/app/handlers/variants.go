@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"net/http"
+)
+
+const (
+	// VariantCookieName - кука с вариантом сплита, путь куки ограничен самой ссылкой
+	VariantCookieName   = "variant"
+	variantCookieMaxAge = 30 * 24 * 60 * 60
+)
+
+// chooseDestination выбирает адрес перехода с учетом правил и сплита и закрепляет посетителя за вариантом
+func (h *Handler) chooseDestination(res http.ResponseWriter, req *http.Request, rec repository.URLRecord) (target, variant string) {
+	if len(rec.Targets) > 0 {
+		// адрес зависит от устройства, кешировать редирект можно только с учетом User-Agent
+		res.Header().Add("Vary", "User-Agent")
+	}
+	if len(rec.Variants) > 0 {
+		// закешированный браузером редирект обходил бы сплит
+		res.Header().Set("Cache-Control", "no-store")
+	}
+	var assigned string
+	if cookie, err := req.Cookie(VariantCookieName); err == nil {
+		assigned = cookie.Value
+	}
+	target, variant = services.ChooseDestination(rec, req.UserAgent(), assigned, userIDFromReq(req))
+	if rec.StickyVariants && variant != "" && variant != assigned {
+		http.SetCookie(res, &http.Cookie{
+			Name:     VariantCookieName,
+			Value:    variant,
+			Path:     "/" + rec.ShortURL,
+			MaxAge:   variantCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return target, variant
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"html/template"
+	"net/http"
+)
+
+// warningPage показывается вместо редиректа по ссылке, адрес которой попал в блок-лист.
+// Адрес выводится текстом, а не ссылкой, чтобы по нему нельзя было перейти одним кликом
+var warningPage = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Link disabled</title>
+</head>
+<body>
+<h1>This link has been disabled</h1>
+<p>It leads to a site that is flagged as malicious: {{.Reason}}.</p>
+<p>Destination: <code>{{.Location}}</code></p>
+</body>
+</html>
+`))
+
+func writeWarning(res http.ResponseWriter, short, location, reason string) {
+	logger.Sugaarz.Warnw("blocked redirect to flagged url", "short", short, "location", location, "reason", reason)
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusForbidden)
+	if err := warningPage.Execute(res, struct{ Location, Reason string }{location, reason}); err != nil {
+		logger.Sugaarz.Errorw("error rendering warning page", "err", err)
+	}
+}
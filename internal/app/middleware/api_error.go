@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"net/http"
+)
+
+// writeAPIError отдает ошибку в том же конверте, что и обработчики /api/*
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(models.APIError{Error: models.APIErrorBody{
+		Code:      code,
+		Message:   msg,
+		RequestID: RequestIDFromContext(r.Context()),
+	}})
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net/http"
+	"strings"
+)
+
+const AuthCookieName = "user_id"
+
+type ctxKey string
+
+const (
+	userIDKey    ctxKey = "userID"
+	anonymousKey ctxKey = "anonymous"
+)
+
+type Authenticator struct {
+	secret []byte
+}
+
+func NewAuthenticator(secret string) *Authenticator {
+	return &Authenticator{secret: []byte(secret)}
+}
+
+// WithAuth кладет в контекст ID пользователя из подписанной куки,
+// а если куки нет или подпись не сходится - выдает новый ID и помечает запрос анонимным
+func (a *Authenticator) WithAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := a.userIDFromCookie(r)
+		if !ok {
+			ctx = WithAnonymous(ctx)
+			userID = uuid.New().String()
+			http.SetCookie(w, &http.Cookie{
+				Name:     AuthCookieName,
+				Value:    a.Sign(userID),
+				Path:     "/",
+				HttpOnly: true,
+			})
+			logger.Sugaarz.Debugw("issued new user id", "user_id", userID)
+		}
+		next(w, r.WithContext(WithUserID(ctx, userID)))
+	}
+}
+
+func (a *Authenticator) userIDFromCookie(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(AuthCookieName)
+	if err != nil {
+		return "", false
+	}
+	return a.Verify(cookie.Value)
+}
+
+// Verify проверяет подписанное значение из куки или метаданных gRPC и возвращает ID пользователя
+func (a *Authenticator) Verify(token string) (string, bool) {
+	userID, signature, found := strings.Cut(token, ".")
+	if !found || userID == "" {
+		return "", false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return "", false
+	}
+	if !hmac.Equal(got, a.mac(userID)) {
+		logger.Sugaarz.Infow("got auth token with bad signature")
+		return "", false
+	}
+	return userID, true
+}
+
+// Sign подписывает ID пользователя, результат принимает Verify
+func (a *Authenticator) Sign(userID string) string {
+	return userID + "." + hex.EncodeToString(a.mac(userID))
+}
+
+func (a *Authenticator) mac(userID string) []byte {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write([]byte(userID))
+	return h.Sum(nil)
+}
+
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey).(string)
+	return userID, ok && userID != ""
+}
+
+func WithAnonymous(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anonymousKey, true)
+}
+
+// IsAnonymous сообщает, что пользователь пришел без действующей куки
+func IsAnonymous(ctx context.Context) bool {
+	anonymous, _ := ctx.Value(anonymousKey).(bool)
+	return anonymous
+}
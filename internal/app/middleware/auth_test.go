@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticator_WithAuth(t *testing.T) {
+	require.NoError(t, logger.InitLogger("dev"))
+	auth := NewAuthenticator("test-secret")
+
+	var gotUserID string
+	handler := auth.WithAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, _ = UserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Issues cookie for new user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, AuthCookieName, cookies[0].Name)
+		assert.NotEmpty(t, gotUserID)
+		assert.Equal(t, auth.Sign(gotUserID), cookies[0].Value)
+	})
+
+	t.Run("Accepts signed cookie", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: AuthCookieName, Value: auth.Sign("user-1")})
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		assert.Empty(t, w.Result().Cookies())
+		assert.Equal(t, "user-1", gotUserID)
+	})
+
+	t.Run("Rejects tampered cookie", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: AuthCookieName, Value: "user-1." + auth.Sign("user-2")[len("user-2."):]})
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		require.Len(t, w.Result().Cookies(), 1)
+		assert.NotEqual(t, "user-1", gotUserID)
+	})
+}
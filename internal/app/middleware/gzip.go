@@ -58,6 +58,19 @@ func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
 	return gw.ResponseWriter.Write(b)
 }
 
+// Flush нужен потоковым ответам: сначала выталкиваем сжатые данные, затем сам ответ
+func (gw *gzipResponseWriter) Flush() {
+	if gw.writer != nil {
+		_ = gw.writer.Flush()
+	}
+	_ = http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap дает http.ResponseController добраться до исходного ResponseWriter
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
 func WithCompress(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
@@ -25,6 +25,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.status = statusCode
 }
 
+// Unwrap дает http.ResponseController добраться до исходного ResponseWriter
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func WithLogging(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -38,11 +43,13 @@ func WithLogging(next http.HandlerFunc) http.HandlerFunc {
 		logger.Sugaarz.Infow("Got request",
 			"uri", r.RequestURI,
 			"method", r.Method,
+			"request_id", RequestIDFromContext(r.Context()),
 			"duration", duration,
 		)
 		logger.Sugaarz.Infow("Sent response",
 			"status", lw.status,
 			"size", lw.size,
+			"request_id", RequestIDFromContext(r.Context()),
 		)
 	}
 }
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const clientIPKey ctxKey = "clientIP"
+
+// TrustedProxies - подсети обратных прокси. Только их заголовкам X-Real-IP и X-Forwarded-For
+// можно верить, остальные клиенты могут подставить в них любой адрес
+type TrustedProxies struct {
+	subnets []*net.IPNet
+}
+
+// NewTrustedProxies с пустым списком не доверяет заголовкам вовсе, адресом клиента считается RemoteAddr
+func NewTrustedProxies(cidrs []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return &TrustedProxies{}, fmt.Errorf("invalid trusted proxy subnet %q: %w", cidr, err)
+		}
+		p.subnets = append(p.subnets, subnet)
+	}
+	return p, nil
+}
+
+// WithClientIP определяет адрес клиента один раз на запрос, дальше его отдает ClientIP
+func (p *TrustedProxies) WithClientIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey, p.clientIP(r))
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// clientIP верит заголовкам, только если запрос пришел от доверенного прокси. В X-Forwarded-For
+// адреса идут справа налево от ближнего прокси, клиент - первый недоверенный из них
+func (p *TrustedProxies) clientIP(r *http.Request) string {
+	remote := remoteHost(r)
+	if !p.trusted(remote) {
+		return remote
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(hops[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		client = ip
+		if !p.trusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func (p *TrustedProxies) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range p.subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP - адрес клиента, определенный WithClientIP. Без этого middleware заголовкам не верит
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
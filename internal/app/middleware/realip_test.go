@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies_WithClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8", " 192.168.0.0/16"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		forwarded  string
+		want       string
+	}{
+		{"No headers", "203.0.113.5:1234", "", "", "203.0.113.5"},
+		{"Headers from untrusted client are ignored", "203.0.113.5:1234", "1.1.1.1", "2.2.2.2", "203.0.113.5"},
+		{"X-Real-IP from trusted proxy", "10.0.0.1:1234", "198.51.100.7", "2.2.2.2", "198.51.100.7"},
+		{"Invalid X-Real-IP falls back to X-Forwarded-For", "10.0.0.1:1234", "garbage", "198.51.100.7", "198.51.100.7"},
+		{"Spoofed X-Forwarded-For head is skipped", "10.0.0.1:1234", "", "1.1.1.1, 198.51.100.7, 192.168.1.1", "198.51.100.7"},
+		{"Only trusted hops", "10.0.0.1:1234", "", "192.168.1.1", "192.168.1.1"},
+		{"Trusted proxy without headers", "10.0.0.1:1234", "", "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			var got string
+			proxies.WithClientIP(func(_ http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})(httptest.NewRecorder(), r)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// без middleware заголовкам не верим
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+	assert.Equal(t, "203.0.113.5", ClientIP(r))
+
+	_, err = NewTrustedProxies([]string{"not-a-cidr"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+
+	requestIDKey ctxKey = "requestID"
+	// maxRequestIDLength - длиннее id клиента не принимаем, чтобы не раздувать логи
+	maxRequestIDLength = 64
+)
+
+// WithRequestID берет id запроса от клиента или прокси, а если его нет - выдает свой
+func WithRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next(w, r.WithContext(WithRequestIDValue(r.Context(), id)))
+	}
+}
+
+func WithRequestIDValue(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"Client id is kept", "req-42", true},
+		{"Missing id is generated", "", false},
+		{"Too long id is replaced", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"Id with spaces is replaced", "req 42", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromCtx string
+			next := func(w http.ResponseWriter, r *http.Request) {
+				fromCtx = RequestIDFromContext(r.Context())
+			}
+			r := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			WithRequestID(next)(w, r)
+
+			assert.NotEmpty(t, fromCtx)
+			assert.Equal(t, fromCtx, w.Header().Get(RequestIDHeader))
+			if tt.keep {
+				assert.Equal(t, tt.incoming, fromCtx)
+			} else {
+				assert.NotEqual(t, tt.incoming, fromCtx)
+			}
+		})
+	}
+}
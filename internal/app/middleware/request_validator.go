@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/go-chi/chi/v5"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const apiPrefix = "/api/"
+
+// RequestValidator проверяет JSON-тела запросов к /api/* по схемам из OpenAPI-спецификации
+type RequestValidator struct {
+	doc *openapi3.T
+}
+
+func NewRequestValidator(doc *openapi3.T) *RequestValidator {
+	return &RequestValidator{doc: doc}
+}
+
+// WithValidation должен стоять после распаковки gzip: операция ищется по шаблону роута chi,
+// поэтому middleware вешается на конкретный роут, а не на весь роутер
+func (v *RequestValidator) WithValidation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schema := v.bodySchema(r)
+		if schema == nil {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, "invalid_body", "error reading body")
+			return
+		}
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, "invalid_body", fmt.Sprintf("failed to decode body: %v", err))
+			return
+		}
+		if err := schema.VisitJSON(value); err != nil {
+			logger.Sugaarz.Infow("request does not match schema", "uri", r.RequestURI, "err", err)
+			writeAPIError(w, r, http.StatusBadRequest, "invalid_body", schemaErrorMessage(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// bodySchema возвращает схему JSON-тела для роута запроса или nil, если проверять нечего
+func (v *RequestValidator) bodySchema(r *http.Request) *openapi3.Schema {
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		return nil
+	}
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return nil
+	}
+	path := v.doc.Paths.Value(rctx.RoutePattern())
+	if path == nil {
+		return nil
+	}
+	op := path.GetOperation(r.Method)
+	if op == nil || op.RequestBody == nil || op.RequestBody.Value == nil {
+		return nil
+	}
+	media := op.RequestBody.Value.Content.Get("application/json")
+	if media == nil || media.Schema == nil {
+		return nil
+	}
+	return media.Schema.Value
+}
+
+// schemaErrorMessage сводит ошибку схемы к полю и причине, без дампа самой схемы
+func schemaErrorMessage(err error) string {
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return err.Error()
+	}
+	// у allOf и oneOf настоящая причина лежит во вложенной ошибке
+	for {
+		var origin *openapi3.SchemaError
+		if !errors.As(schemaErr.Origin, &origin) {
+			break
+		}
+		schemaErr = origin
+	}
+	field := "body"
+	if ptr := schemaErr.JSONPointer(); len(ptr) > 0 {
+		field = strings.Join(ptr, ".")
+	}
+	return fmt.Sprintf("%s: %s", field, schemaErr.Reason)
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/stlesnik/url_shortener/internal/app/models"
+	"github.com/stlesnik/url_shortener/internal/app/openapi"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestValidator_WithValidation(t *testing.T) {
+	require.NoError(t, logger.InitLogger("dev"))
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+	validator := NewRequestValidator(doc)
+
+	var gotBody string
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}
+	router := chi.NewRouter()
+	router.Post("/api/shorten", validator.WithValidation(echo))
+	router.Post("/api/shorten/batch", validator.WithValidation(echo))
+	router.Patch("/api/shorten/{id}", validator.WithValidation(echo))
+	router.Post("/", validator.WithValidation(echo))
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		wantCode    int
+		wantMessage string
+	}{
+		{"Valid body", http.MethodPost, "/api/shorten", `{"url":"https://ya.ru","ttl_seconds":60}`, http.StatusOK, ""},
+		{"Missing url", http.MethodPost, "/api/shorten", `{"alias":"promo"}`, http.StatusBadRequest, `property "url" is missing`},
+		{"Wrong type", http.MethodPost, "/api/shorten", `{"url":"https://ya.ru","ttl_seconds":"60"}`, http.StatusBadRequest, "ttl_seconds"},
+		{"Malformed json", http.MethodPost, "/api/shorten", `{"url":`, http.StatusBadRequest, "failed to decode body"},
+		{"Batch item", http.MethodPost, "/api/shorten/batch", `[{"correlation_id":"1"}]`, http.StatusBadRequest, "original_url"},
+		{"Path param route", http.MethodPatch, "/api/shorten/promo", `{"redirect_code":303}`, http.StatusBadRequest, "redirect_code"},
+		{"Empty patch", http.MethodPatch, "/api/shorten/promo", `{}`, http.StatusBadRequest, ""},
+		{"Plain text route is skipped", http.MethodPost, "/", `https://ya.ru`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBody = ""
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, tt.body, gotBody)
+				return
+			}
+			var apiErr models.APIError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &apiErr))
+			assert.Equal(t, "invalid_body", apiErr.Error.Code)
+			assert.Contains(t, apiErr.Error.Message, tt.wantMessage)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net"
+	"net/http"
+)
+
+// TrustedSubnet пропускает только клиентов из подсети, адрес клиента берется из ClientIP,
+// поэтому за прокси нужен еще и WithClientIP с доверенными прокси
+type TrustedSubnet struct {
+	subnet *net.IPNet
+}
+
+// NewTrustedSubnet с пустым cidr возвращает фильтр, который никого не пропускает
+func NewTrustedSubnet(cidr string) (*TrustedSubnet, error) {
+	if cidr == "" {
+		return &TrustedSubnet{}, nil
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return &TrustedSubnet{}, fmt.Errorf("invalid trusted subnet %q: %w", cidr, err)
+	}
+	return &TrustedSubnet{subnet: subnet}, nil
+}
+
+func (t *TrustedSubnet) WithTrustedSubnet(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(ClientIP(r))
+		if t.subnet == nil || ip == nil || !t.subnet.Contains(ip) {
+			logger.Sugaarz.Infow("request from untrusted address", "ip", ClientIP(r), "uri", r.RequestURI)
+			writeAPIError(w, r, http.StatusForbidden, "forbidden", "access is allowed only from trusted subnet")
+			return
+		}
+		next(w, r)
+	}
+}
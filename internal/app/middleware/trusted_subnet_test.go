@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedSubnet_WithTrustedSubnet(t *testing.T) {
+	require.NoError(t, logger.InitLogger("dev"))
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name       string
+		cidr       string
+		realIP     string
+		remoteAddr string
+		wantCode   int
+	}{
+		{"X-Real-IP in subnet", "10.0.0.0/8", "10.1.2.3", "192.168.1.1:1234", http.StatusOK},
+		{"X-Real-IP outside subnet", "10.0.0.0/8", "192.168.1.1", "10.1.2.3:1234", http.StatusForbidden},
+		{"Remote address in subnet", "192.168.0.0/16", "", "192.168.1.1:1234", http.StatusOK},
+		{"Empty subnet denies all", "", "10.1.2.3", "10.1.2.3:1234", http.StatusForbidden},
+		{"X-Real-IP from untrusted proxy is ignored", "10.0.0.0/8", "10.1.2.3", "203.0.113.5:1234", http.StatusForbidden},
+	}
+	// X-Real-IP принимается только от прокси из этих подсетей
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8", "192.168.0.0/16"})
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := NewTrustedSubnet(tt.cidr)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			proxies.WithClientIP(trusted.WithTrustedSubnet(ok))(w, r)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+
+	_, err = NewTrustedSubnet("not-a-cidr")
+	assert.Error(t, err)
+}
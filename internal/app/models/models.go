@@ -1,21 +1,180 @@
 package models
 
+import "time"
+
+// LinkOptions - общие для одиночного и пакетного сокращения параметры ссылки
+type LinkOptions struct {
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds   *int64     `json:"ttl_seconds,omitempty"`
+	Password     string     `json:"password,omitempty"`
+	RedirectCode int        `json:"redirect_code,omitempty"`
+	Title        string     `json:"title,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	// Passthrough - override или keep: переход по /{id}/path?query дописывает путь и параметры к адресу
+	Passthrough string `json:"passthrough,omitempty"`
+	// Targets - правила по устройству клиента, первое подходящее задает адрес перехода
+	Targets []TargetRule `json:"targets,omitempty"`
+	// Variants - A/B-сплит: переход ведет на один из адресов пропорционально весам
+	Variants       []Variant `json:"variants,omitempty"`
+	StickyVariants bool      `json:"sticky_variants,omitempty"`
+}
+
+// Variant - безымянные варианты получают имена a, b, c... по порядку
+type Variant struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// TargetRule - os: ios, android, windows, macos, linux; device: mobile, tablet, desktop, bot
+type TargetRule struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	URL    string `json:"url"`
+}
+
+// APIError - единый конверт ошибок для всех /api/* роутов
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+// Code стабилен и предназначен для программ, Message - для людей и может меняться
+type APIErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
 // APIPrepareShortURL
 type APIRequestPrepareShURL struct {
 	LongURL string `json:"url"`
+	Alias   string `json:"alias,omitempty"`
+	LinkOptions
 }
 
 type APIResponsePrepareShURL struct {
-	ShortURL string `json:"result"`
+	ShortURL  string     `json:"result"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 // APIPrepareBatchShortURL
 type APIRequestPrepareBatchShURL struct {
 	CorrelationID string `json:"correlation_id"`
 	LongURL       string `json:"original_url"`
+	LinkOptions
 }
 
+// Status - created, existing или invalid, у invalid вместо short_url заполнен error
 type APIResponsePrepareBatchShURL struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+}
+
+// APIPrepareStreamShortURL: на каждую непустую строку запроса - строка ответа, line считается с 1
+type APIResponseStreamShURL struct {
+	Line int `json:"line"`
+	APIResponsePrepareBatchShURL
+}
+
+// ImportURLs: строка ответа на строку CSV, line - номер строки в файле
+type APIResponseImportRow struct {
+	Line        int    `json:"line"`
+	OriginalURL string `json:"original_url"`
+	ShortURL    string `json:"short_url,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ExportUserURLs, alias заполнен только у пользовательских id
+type APIResponseExportURL struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Alias       string     `json:"alias,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// GetUserURLs
+type APIResponseUserURL struct {
+	ShortURL    string       `json:"short_url"`
+	OriginalURL string       `json:"original_url"`
+	Title       string       `json:"title,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	Targets     []TargetRule `json:"targets,omitempty"`
+	Variants    []Variant    `json:"variants,omitempty"`
+	CreatedAt   time.Time    `json:"created_at,omitzero"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+}
+
+// UpdateShortURL, tags, targets и variants заменяются целиком
+type APIRequestUpdateShURL struct {
+	LongURL        *string       `json:"url,omitempty"`
+	RedirectCode   *int          `json:"redirect_code,omitempty"`
+	Title          *string       `json:"title,omitempty"`
+	Tags           *[]string     `json:"tags,omitempty"`
+	Passthrough    *string       `json:"passthrough,omitempty"`
+	Targets        *[]TargetRule `json:"targets,omitempty"`
+	Variants       *[]Variant    `json:"variants,omitempty"`
+	StickyVariants *bool         `json:"sticky_variants,omitempty"`
+}
+
+type APIResponseLink struct {
+	ShortURL       string       `json:"short_url"`
+	OriginalURL    string       `json:"original_url"`
+	RedirectCode   int          `json:"redirect_code"`
+	Title          string       `json:"title,omitempty"`
+	Tags           []string     `json:"tags,omitempty"`
+	Passthrough    string       `json:"passthrough,omitempty"`
+	Targets        []TargetRule `json:"targets,omitempty"`
+	Variants       []Variant    `json:"variants,omitempty"`
+	StickyVariants bool         `json:"sticky_variants,omitempty"`
+	ExpiresAt      *time.Time   `json:"expires_at,omitempty"`
+}
+
+// GetLongURL с предпросмотром
+type APIResponseLinkPreview struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// ExpandShortURL
+type APIResponseExpandURL struct {
+	ShortURL     string     `json:"short_url,omitempty"`
+	OriginalURL  string     `json:"original_url,omitempty"`
+	RedirectCode int        `json:"redirect_code,omitempty"`
+	Title        string     `json:"title,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	CreatedAt    time.Time  `json:"created_at,omitzero"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// ExpandBatch: short - ссылка из запроса как есть, status - found или код ошибки
+type APIResponseExpandBatchItem struct {
+	Short  string `json:"short"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	APIResponseExpandURL
+}
+
+// GetLinkStats
+type APIResponseLinkStats struct {
+	ShortURL   string           `json:"short_url"`
+	Total      int64            `json:"total"`
+	ByDay      map[string]int64 `json:"by_day"`
+	ByReferrer map[string]int64 `json:"by_referrer"`
+	ByBrowser  map[string]int64 `json:"by_browser"`
+	// ByVariant - переходы по вариантам сплита, только у ссылок со сплитом
+	ByVariant map[string]int64 `json:"by_variant,omitempty"`
+}
+
+// GetInternalStats
+type APIResponseInternalStats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
 }
@@ -0,0 +1,128 @@
+// Package openapi хранит описание API, по которому партнеры генерируют клиентов
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"html/template"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Spec - описание API в формате OpenAPI 3, его отдают как есть
+//
+//go:embed openapi.json
+var Spec []byte
+
+//go:embed docs.html
+var docsTemplate string
+
+var docsPage = template.Must(template.New("docs").Parse(docsTemplate))
+
+// DocsPage - страница документации. Рисуется на сервере один раз из встроенной спецификации,
+// поэтому не нужны ни сторонние скрипты, ни доступ в интернет
+var DocsPage = sync.OnceValues(func() ([]byte, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return renderDocs(doc)
+})
+
+// Load разбирает встроенную спецификацию и проверяет ее на корректность
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load openapi spec: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+	return doc, nil
+}
+
+type docsParameter struct {
+	Name, In, Description string
+	Required              bool
+}
+
+type docsResponse struct {
+	Status, Description string
+}
+
+type docsOperation struct {
+	ID, Method, Path, Summary, Description string
+	Parameters                             []docsParameter
+	RequestTypes                           []string
+	Responses                              []docsResponse
+}
+
+// methodOrder - порядок методов внутри одного пути
+var methodOrder = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS", "TRACE"}
+
+func renderDocs(doc *openapi3.T) ([]byte, error) {
+	data := struct {
+		Title, Version, Description string
+		Operations                  []docsOperation
+	}{Title: "API"}
+	if doc.Info != nil {
+		data.Title, data.Version, data.Description = doc.Info.Title, doc.Info.Version, doc.Info.Description
+	}
+
+	paths := doc.Paths.InMatchingOrder()
+	slices.Sort(paths)
+	for _, path := range paths {
+		item := doc.Paths.Value(path)
+		ops := item.Operations()
+		for _, method := range methodOrder {
+			op, ok := ops[method]
+			if !ok {
+				continue
+			}
+			data.Operations = append(data.Operations, docsOperationOf(path, method, item, op))
+		}
+	}
+
+	var b strings.Builder
+	if err := docsPage.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("failed to render docs page: %w", err)
+	}
+	return []byte(b.String()), nil
+}
+
+func docsOperationOf(path, method string, item *openapi3.PathItem, op *openapi3.Operation) docsOperation {
+	res := docsOperation{
+		ID:          op.OperationID,
+		Method:      method,
+		Path:        path,
+		Summary:     op.Summary,
+		Description: op.Description,
+	}
+	for _, params := range []openapi3.Parameters{item.Parameters, op.Parameters} {
+		for _, ref := range params {
+			if p := ref.Value; p != nil {
+				res.Parameters = append(res.Parameters, docsParameter{Name: p.Name, In: p.In, Description: p.Description, Required: p.Required})
+			}
+		}
+	}
+	if op.RequestBody != nil && op.RequestBody.Value != nil {
+		for contentType := range op.RequestBody.Value.Content {
+			res.RequestTypes = append(res.RequestTypes, contentType)
+		}
+		slices.Sort(res.RequestTypes)
+	}
+	if op.Responses != nil {
+		for status, ref := range op.Responses.Map() {
+			resp := docsResponse{Status: status}
+			if ref.Value != nil && ref.Value.Description != nil {
+				resp.Description = *ref.Value.Description
+			}
+			res.Responses = append(res.Responses, resp)
+		}
+		slices.SortFunc(res.Responses, func(a, b docsResponse) int { return strings.Compare(a.Status, b.Status) })
+	}
+	return res
+}
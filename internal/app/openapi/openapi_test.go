@@ -0,0 +1,26 @@
+package openapi
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	require.NoError(t, err)
+	assert.NotNil(t, doc.Paths.Value("/api/shorten"))
+	assert.NotNil(t, doc.Components.Schemas["Error"])
+}
+
+func TestDocsPage(t *testing.T) {
+	page, err := DocsPage()
+	require.NoError(t, err)
+	html := string(page)
+	assert.Contains(t, html, `<code>/api/shorten</code>`)
+	assert.Contains(t, html, `id="deleteUserURLs"`)
+	assert.Contains(t, html, `href="openapi.json"`)
+	// страница работает без сети и не тянет чужие скрипты
+	assert.NotContains(t, html, "<script")
+	assert.NotContains(t, html, "https://")
+}
@@ -0,0 +1,68 @@
+package repository
+
+import "time"
+
+const clickDayLayout = "2006-01-02"
+
+// ClickEvent - один переход по короткой ссылке
+type ClickEvent struct {
+	ShortURL       string    `db:"short_url" json:"short_url"`
+	ClickedAt      time.Time `db:"clicked_at" json:"clicked_at"`
+	Referrer       string    `db:"referrer" json:"referrer,omitempty"`
+	ReferrerDomain string    `db:"referrer_domain" json:"referrer_domain"`
+	UserAgent      string    `db:"user_agent" json:"user_agent,omitempty"`
+	Browser        string    `db:"browser" json:"browser"`
+	ClientIP       string    `db:"client_ip" json:"client_ip,omitempty"`
+	// Variant - имя варианта сплита, на который ушел посетитель, пустое у ссылок без сплита
+	Variant string `db:"variant" json:"variant,omitempty"`
+}
+
+// ClickStats - агрегированная статистика переходов по ссылке
+type ClickStats struct {
+	Total      int64
+	ByDay      map[string]int64
+	ByReferrer map[string]int64
+	ByBrowser  map[string]int64
+	ByVariant  map[string]int64
+}
+
+func newClickStats() *ClickStats {
+	return &ClickStats{
+		ByDay:      make(map[string]int64),
+		ByReferrer: make(map[string]int64),
+		ByBrowser:  make(map[string]int64),
+		ByVariant:  make(map[string]int64),
+	}
+}
+
+func (s *ClickStats) add(ev ClickEvent) {
+	s.Total++
+	s.ByDay[ev.ClickedAt.UTC().Format(clickDayLayout)]++
+	s.ByReferrer[ev.ReferrerDomain]++
+	s.ByBrowser[ev.Browser]++
+	if ev.Variant != "" {
+		s.ByVariant[ev.Variant]++
+	}
+}
+
+// copy нужен, чтобы вызывающий не держал ссылки на внутренние map хранилища
+func (s *ClickStats) copy() ClickStats {
+	res := *newClickStats()
+	if s == nil {
+		return res
+	}
+	res.Total = s.Total
+	for k, v := range s.ByDay {
+		res.ByDay[k] = v
+	}
+	for k, v := range s.ByReferrer {
+		res.ByReferrer[k] = v
+	}
+	for k, v := range s.ByBrowser {
+		res.ByBrowser[k] = v
+	}
+	for k, v := range s.ByVariant {
+		res.ByVariant[k] = v
+	}
+	return res
+}
@@ -3,19 +3,49 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
 	"github.com/stlesnik/url_shortener/internal/logger"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 const (
 	ErrCodeUniqueViolation = "23505" // unique_violation
+	shortURLConstraint     = "url_short_url_key"
 )
 
+const (
+	// tagsColumn собирает теги из url_tags в json-массив, его разбирает Tags.Scan
+	tagsColumn       = "COALESCE((SELECT json_agg(tag ORDER BY tag) FROM url_tags WHERE url_tags.short_url = url.short_url), '[]') AS tags"
+	urlRecordColumns = "short_url, long_url, COALESCE(user_id, '') AS user_id, is_deleted, expires_at, created_at, password_hash, redirect_code, title, passthrough, targets, variants, sticky_variants, " + tagsColumn
+	selectURLRecord  = "SELECT " + urlRecordColumns + " FROM url "
+	insertURLRecord  = "INSERT INTO url (short_url, long_url, user_id, expires_at, password_hash, redirect_code, title, passthrough, targets, variants, sticky_variants) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::jsonb, $10::jsonb, $11) "
+	insertTags = "INSERT INTO url_tags (short_url, tag) SELECT $1, unnest($2::varchar[]) ON CONFLICT DO NOTHING"
+	// retireExpired не дожидается Reaper: истекшая ссылка перестает держать url, и его можно сократить заново
+	retireExpired = "UPDATE url SET is_deleted = TRUE WHERE long_url = $1 AND NOT is_deleted AND expires_at <= now()"
+	// liveURL - ссылка не удалена и не истекла, только такая держит за собой url
+	liveURL = "NOT is_deleted AND (expires_at IS NULL OR expires_at > now())"
+)
+
+// sortColumns - колонки url для полей сортировки URLQuery
+var sortColumns = map[SortField]string{
+	SortCreatedAt:   "created_at",
+	SortOriginalURL: "long_url",
+	SortTitle:       "title",
+}
+
+// likeEscaper экранирует спецсимволы LIKE, чтобы поиск был по подстроке как есть
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 type DataBase struct {
 	db *sqlx.DB
 }
@@ -36,12 +66,22 @@ func (d *DataBase) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (d *DataBase) Save(ctx context.Context, short string, long string) (isDouble bool, err error) {
-	_, dbErr := d.db.ExecContext(ctx, "INSERT INTO url (short_url, long_url) VALUES ($1, $2)", short, long)
+func (d *DataBase) Save(ctx context.Context, rec URLRecord) (isDouble bool, err error) {
+	if _, err := d.db.ExecContext(ctx, retireExpired, rec.OriginalURL); err != nil {
+		return false, fmt.Errorf("error while saving url: %w: %v", ErrSaveURL, err)
+	}
+	// одним запросом вместе с тегами, чтобы ссылка не осталась без них
+	_, dbErr := d.db.ExecContext(ctx, ""+
+		"WITH ins AS ("+insertURLRecord+"RETURNING short_url) "+
+		"INSERT INTO url_tags (short_url, tag) SELECT ins.short_url, unnest($12::varchar[]) FROM ins",
+		rec.ShortURL, rec.OriginalURL, rec.UserID, rec.ExpiresAt, rec.PasswordHash, rec.RedirectCode, rec.Title, rec.Passthrough, rec.Targets, rec.Variants, rec.StickyVariants, []string(rec.Tags))
 	if dbErr != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(dbErr, &pgErr) && pgErr.Code == ErrCodeUniqueViolation {
-			logger.Sugaarz.Infow("this short url already exists", "short", short, "long", long)
+			if pgErr.ConstraintName == shortURLConstraint {
+				return d.checkShortOwner(ctx, rec.ShortURL, rec.OriginalURL)
+			}
+			logger.Sugaarz.Infow("this short url already exists", "short", rec.ShortURL, "long", rec.OriginalURL)
 			return true, nil
 		}
 		logger.Sugaarz.Errorf("error while saving url: %w: %v", ErrSaveURL, dbErr)
@@ -50,42 +90,368 @@ func (d *DataBase) Save(ctx context.Context, short string, long string) (isDoubl
 	return false, nil
 }
 
-type URLPair struct {
-	URLHash string
-	LongURL string
+// checkShortOwner отличает повторное сокращение того же url от занятого алиаса.
+// Удаленная ссылка держит свой id, но не url, поэтому для нового сокращения id занят
+func (d *DataBase) checkShortOwner(ctx context.Context, short string, long string) (isDouble bool, err error) {
+	var existing struct {
+		LongURL   string `db:"long_url"`
+		IsDeleted bool   `db:"is_deleted"`
+	}
+	if err := d.db.GetContext(ctx, &existing, "SELECT long_url, is_deleted FROM url WHERE short_url = $1", short); err != nil {
+		return false, fmt.Errorf("error while saving url: %w: %v", ErrSaveURL, err)
+	}
+	if existing.LongURL != long || existing.IsDeleted {
+		return false, ErrShortURLTaken
+	}
+	logger.Sugaarz.Infow("this short url already exists", "short", short, "long", long)
+	return true, nil
 }
 
-func (d *DataBase) SaveBatch(ctx context.Context, batch []URLPair) error {
-	tx, err := d.db.Begin()
+// SaveBatch сохраняет пакет в одной транзакции и для каждой записи сообщает, создана ли она,
+// а для уже сокращенных url - под каким id они лежат
+func (d *DataBase) SaveBatch(ctx context.Context, batch []URLRecord) ([]BatchResult, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error while beginning transaction: %w: %v", ErrBeginTransaction, err)
+		return nil, fmt.Errorf("error while beginning transaction: %w: %v", ErrBeginTransaction, err)
 	}
 
-	for _, pair := range batch {
-		_, err := tx.ExecContext(ctx, ""+
-			"INSERT INTO url (short_url, long_url) "+
-			"VALUES ($1, $2) "+
-			"ON CONFLICT (long_url) DO NOTHING", pair.URLHash, pair.LongURL)
-		if err != nil {
+	results := make([]BatchResult, len(batch))
+	for i, rec := range batch {
+		if _, err := tx.ExecContext(ctx, retireExpired, rec.OriginalURL); err != nil {
+			_ = tx.Rollback()
+			return nil, fmt.Errorf("error while creating SQL statement in transaction: %w", err)
+		}
+		var short string
+		err := tx.GetContext(ctx, &short, insertURLRecord+"ON CONFLICT DO NOTHING RETURNING short_url",
+			rec.ShortURL, rec.OriginalURL, rec.UserID, rec.ExpiresAt, rec.PasswordHash, rec.RedirectCode, rec.Title, rec.Passthrough, rec.Targets, rec.Variants, rec.StickyVariants)
+		if err == nil && len(rec.Tags) > 0 {
+			_, err = tx.ExecContext(ctx, insertTags, short, []string(rec.Tags))
+		}
+		if err == nil {
+			results[i] = BatchResult{ShortURL: short, Created: true}
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			_ = tx.Rollback()
+			return nil, fmt.Errorf("error while creating SQL statement in transaction: %w", err)
+		}
+
+		// строка не вставилась: либо url уже сокращен, либо id занят другим url или удаленной ссылкой
+		err = tx.GetContext(ctx, &short, "SELECT short_url FROM url WHERE long_url = $1 AND NOT is_deleted", rec.OriginalURL)
+		switch {
+		case err == nil:
+			results[i] = BatchResult{ShortURL: short}
+		case errors.Is(err, sql.ErrNoRows):
+			results[i] = BatchResult{Err: ErrShortURLTaken}
+		default:
 			_ = tx.Rollback()
-			return fmt.Errorf("error while creating SQL statement in transaction: %w", err)
+			return nil, fmt.Errorf("error while creating SQL statement in transaction: %w", err)
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error while saving batch: %w: %v", ErrSaveURL, err)
+	}
+	return results, nil
+}
+
+func (d *DataBase) Get(ctx context.Context, short string) (URLRecord, error) {
+	var rec URLRecord
+	err := d.db.GetContext(ctx, &rec, selectURLRecord+"WHERE short_url = $1", short)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLRecord{}, ErrURLNotFound
+	}
+	if err != nil {
+		return URLRecord{}, fmt.Errorf("error while getting short url: %w: %v", ErrGetURL, err)
+	}
+	if rec.IsDeleted {
+		return URLRecord{}, ErrURLDeleted
+	}
+	logger.Sugaarz.Infow("Got short url from db", "short", short, "long", rec.OriginalURL)
+	return rec, nil
+}
+
+// GetByOriginalURL ищет живую ссылку по исходному url, удаленные и истекшие url за собой не держат
+func (d *DataBase) GetByOriginalURL(ctx context.Context, longURL string) (URLRecord, error) {
+	var rec URLRecord
+	err := d.db.GetContext(ctx, &rec, selectURLRecord+"WHERE long_url = $1 AND "+liveURL, longURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLRecord{}, ErrURLNotFound
+	}
+	if err != nil {
+		return URLRecord{}, fmt.Errorf("error while getting short url: %w: %v", ErrGetURL, err)
+	}
+	return rec, nil
+}
+
+func (d *DataBase) GetUserURLs(ctx context.Context, userID string) ([]URLRecord, error) {
+	var urls []URLRecord
+	err := d.db.SelectContext(ctx, &urls, selectURLRecord+""+
+		"WHERE user_id = $1 AND "+liveURL+" "+
+		"ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting user urls: %w: %v", ErrGetURL, err)
+	}
+	return urls, nil
+}
+
+// FindUserURLs - GetUserURLs с фильтрами, сортировкой и постраничной выдачей по курсору
+func (d *DataBase) FindUserURLs(ctx context.Context, q URLQuery) ([]URLRecord, error) {
+	args := []any{q.UserID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	where := []string{"user_id = $1", "NOT is_deleted", "(expires_at IS NULL OR expires_at > now())"}
+	if len(q.Tags) > 0 {
+		where = append(where, "short_url IN (SELECT short_url FROM url_tags WHERE tag = ANY("+arg(q.Tags)+"::varchar[]) "+
+			"GROUP BY short_url HAVING count(*) = "+arg(len(q.Tags))+")")
+	}
+	if q.Search != "" {
+		pattern := arg("%" + likeEscaper.Replace(q.Search) + "%")
+		where = append(where, "(long_url ILIKE "+pattern+" OR title ILIKE "+pattern+" OR short_url ILIKE "+pattern+")")
+	}
+	column, ok := sortColumns[q.Sort]
+	if !ok {
+		column = sortColumns[SortCreatedAt]
+	}
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+	if q.After != nil {
+		key := arg(q.After.Key)
+		if column == sortColumns[SortCreatedAt] {
+			key += "::timestamptz"
+		}
+		where = append(where, "("+column+", short_url) "+op+" ("+key+", "+arg(q.After.ShortURL)+")")
+	}
+	query := selectURLRecord + "WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + column + " " + dir + ", short_url " + dir
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	var urls []URLRecord
+	if err := d.db.SelectContext(ctx, &urls, query, args...); err != nil {
+		return nil, fmt.Errorf("error while finding user urls: %w: %v", ErrGetURL, err)
+	}
+	return urls, nil
 }
 
-func (d *DataBase) Get(ctx context.Context, short string) (string, error) {
-	var longURL string
-	err := d.db.GetContext(ctx, &longURL, "SELECT long_url FROM url WHERE short_url = $1", short)
+func (d *DataBase) GetStats(ctx context.Context) (ServiceStats, error) {
+	var stats ServiceStats
+	err := d.db.GetContext(ctx, &stats, ""+
+		"SELECT count(*) FILTER (WHERE NOT is_deleted) AS urls, "+
+		"count(DISTINCT NULLIF(user_id, '')) AS users FROM url")
+	if err != nil {
+		return ServiceStats{}, fmt.Errorf("error while getting stats: %w: %v", ErrGetURL, err)
+	}
+	return stats, nil
+}
+
+// Update меняет ссылку и ее теги в одной транзакции и только если ссылка принадлежит userID
+func (d *DataBase) Update(ctx context.Context, short, userID string, patch URLPatch) (URLRecord, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return URLRecord{}, fmt.Errorf("error while beginning transaction: %w: %v", ErrBeginTransaction, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if patch.OriginalURL != nil {
+		if _, err := tx.ExecContext(ctx, retireExpired, *patch.OriginalURL); err != nil {
+			return URLRecord{}, fmt.Errorf("error while updating url: %w: %v", ErrUpdateURL, err)
+		}
+	}
+	var rec URLRecord
+	err = tx.GetContext(ctx, &rec, ""+
+		"UPDATE url SET long_url = COALESCE($3, long_url), redirect_code = COALESCE($4, redirect_code), "+
+		"title = COALESCE($5, title), passthrough = COALESCE($6, passthrough), "+
+		"targets = COALESCE($7::jsonb, targets), variants = COALESCE($8::jsonb, variants), "+
+		"sticky_variants = COALESCE($9, sticky_variants) "+
+		"WHERE short_url = $1 AND user_id = $2 AND NOT is_deleted "+
+		"RETURNING "+urlRecordColumns, short, userID, patch.OriginalURL, patch.RedirectCode, patch.Title, patch.Passthrough, patch.Targets, patch.Variants, patch.StickyVariants)
 	if errors.Is(err, sql.ErrNoRows) {
-		return "", ErrURLNotFound
+		return URLRecord{}, ErrURLNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == ErrCodeUniqueViolation {
+		return URLRecord{}, ErrLongURLTaken
 	}
 	if err != nil {
-		return "", fmt.Errorf("error while getting short url: %w: %v", ErrGetURL, err)
+		return URLRecord{}, fmt.Errorf("error while updating url: %w: %v", ErrUpdateURL, err)
 	}
-	logger.Sugaarz.Infow("Got short url from db", "short", short, "long", longURL)
-	return longURL, nil
+	if patch.Tags != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM url_tags WHERE short_url = $1", short); err != nil {
+			return URLRecord{}, fmt.Errorf("error while updating tags: %w: %v", ErrUpdateURL, err)
+		}
+		if _, err := tx.ExecContext(ctx, insertTags, short, *patch.Tags); err != nil {
+			return URLRecord{}, fmt.Errorf("error while updating tags: %w: %v", ErrUpdateURL, err)
+		}
+		rec.Tags = *patch.Tags
+	}
+	if err := tx.Commit(); err != nil {
+		return URLRecord{}, fmt.Errorf("error while updating url: %w: %v", ErrUpdateURL, err)
+	}
+	return rec, nil
+}
+
+type DeleteRequest struct {
+	UserID  string
+	URLHash string
+}
+
+// DeleteURLs помечает ссылки удаленными одним запросом, чужие ссылки не трогает
+func (d *DataBase) DeleteURLs(ctx context.Context, batch []DeleteRequest) error {
+	userIDs := make([]string, 0, len(batch))
+	hashes := make([]string, 0, len(batch))
+	for _, req := range batch {
+		userIDs = append(userIDs, req.UserID)
+		hashes = append(hashes, req.URLHash)
+	}
+	_, err := d.db.ExecContext(ctx, ""+
+		"UPDATE url SET is_deleted = TRUE "+
+		"FROM (SELECT unnest($1::varchar[]) AS user_id, unnest($2::varchar[]) AS short_url) AS del "+
+		"WHERE url.user_id = del.user_id AND url.short_url = del.short_url", userIDs, hashes)
+	if err != nil {
+		return fmt.Errorf("error while deleting urls: %w: %v", ErrDeleteURL, err)
+	}
+	return nil
+}
+
+// PurgeExpired помечает истекшие ссылки удаленными, чтобы по ним продолжал отдаваться 410
+func (d *DataBase) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	res, err := d.db.ExecContext(ctx, ""+
+		"UPDATE url SET is_deleted = TRUE "+
+		"WHERE expires_at <= $1 AND NOT is_deleted", now)
+	if err != nil {
+		return 0, fmt.Errorf("error while purging expired urls: %w: %v", ErrDeleteURL, err)
+	}
+	return res.RowsAffected()
+}
+
+func (d *DataBase) SaveClicks(ctx context.Context, events []ClickEvent) error {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error while beginning transaction: %w: %v", ErrBeginTransaction, err)
+	}
+	stmt, err := tx.PrepareNamedContext(ctx, ""+
+		"INSERT INTO clicks (short_url, clicked_at, referrer, referrer_domain, user_agent, browser, client_ip, variant) "+
+		"VALUES (:short_url, :clicked_at, :referrer, :referrer_domain, :user_agent, :browser, :client_ip, :variant)")
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("error while preparing clicks statement: %w: %v", ErrSaveClicks, err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+	for _, ev := range events {
+		if _, err := stmt.ExecContext(ctx, ev); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error while saving clicks: %w: %v", ErrSaveClicks, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *DataBase) GetClickStats(ctx context.Context, short string) (ClickStats, error) {
+	stats := *newClickStats()
+	if err := d.db.GetContext(ctx, &stats.Total, "SELECT count(*) FROM clicks WHERE short_url = $1", short); err != nil {
+		return ClickStats{}, fmt.Errorf("error while getting clicks total: %w: %v", ErrGetClicks, err)
+	}
+	breakdowns := []struct {
+		expr   string
+		target map[string]int64
+	}{
+		{"to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')", stats.ByDay},
+		{"referrer_domain", stats.ByReferrer},
+		{"browser", stats.ByBrowser},
+		{"variant", stats.ByVariant},
+	}
+	for _, b := range breakdowns {
+		var rows []struct {
+			Key   string `db:"key"`
+			Count int64  `db:"count"`
+		}
+		query := "SELECT " + b.expr + " AS key, count(*) AS count FROM clicks WHERE short_url = $1 GROUP BY 1"
+		if err := d.db.SelectContext(ctx, &rows, query, short); err != nil {
+			return ClickStats{}, fmt.Errorf("error while getting clicks breakdown: %w: %v", ErrGetClicks, err)
+		}
+		for _, row := range rows {
+			b.target[row.Key] = row.Count
+		}
+	}
+	// переходы по ссылкам без сплита пишутся с пустым вариантом
+	delete(stats.ByVariant, "")
+	return stats, nil
+}
+
+// Tags - теги записи, из бд приходят json-массивом из tagsColumn
+type Tags []string
+
+func (t *Tags) Scan(src any) error {
+	return scanJSON(src, (*[]string)(t), "tags")
+}
+
+// TargetRules - правила записи, в бд лежат jsonb-массивом в колонке targets
+type TargetRules []TargetRule
+
+func (r TargetRules) Value() (driver.Value, error) {
+	return jsonValue([]TargetRule(r), "target rules")
+}
+
+func (r *TargetRules) Scan(src any) error {
+	return scanJSON(src, (*[]TargetRule)(r), "target rules")
+}
+
+// Variants - варианты сплита, в бд лежат jsonb-массивом в колонке variants
+type Variants []Variant
+
+func (v Variants) Value() (driver.Value, error) {
+	return jsonValue([]Variant(v), "variants")
+}
+
+func (v *Variants) Scan(src any) error {
+	return scanJSON(src, (*[]Variant)(v), "variants")
+}
+
+// jsonValue кодирует срез для jsonb-колонки, nil пишется пустым массивом
+func jsonValue[T any](items []T, what string) (driver.Value, error) {
+	if items == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return nil, fmt.Errorf("can not encode %s: %w", what, err)
+	}
+	return string(b), nil
+}
+
+// scanJSON разбирает jsonb-массив, пустой массив становится nil, как в записях из памяти
+func scanJSON[T any](src any, dst *[]T, what string) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*dst = nil
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("can not scan %T into %s", src, what)
+	}
+	var items []T
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return fmt.Errorf("can not scan %s: %w", what, err)
+	}
+	if len(items) == 0 {
+		items = nil
+	}
+	*dst = items
+	return nil
 }
 
 func (d *DataBase) Close() error {
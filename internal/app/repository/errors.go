@@ -4,10 +4,17 @@ import "errors"
 
 var (
 	ErrURLNotFound      = errors.New("url not found")
+	ErrURLDeleted       = errors.New("url is deleted")
+	ErrShortURLTaken    = errors.New("short url is taken by another url")
+	ErrLongURLTaken     = errors.New("long url is already shortened")
 	ErrOpenDB           = errors.New("error while opening db")
 	ErrWarmDB           = errors.New("error while warming db up")
 	ErrPingDB           = errors.New("error while ping to db")
 	ErrSaveURL          = errors.New("error while saving url")
 	ErrGetURL           = errors.New("error while getting url")
 	ErrBeginTransaction = errors.New("error while beginning transaction")
+	ErrDeleteURL        = errors.New("error while deleting url")
+	ErrUpdateURL        = errors.New("error while updating url")
+	ErrSaveClicks       = errors.New("error while saving clicks")
+	ErrGetClicks        = errors.New("error while getting clicks")
 )
@@ -4,17 +4,28 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/stlesnik/url_shortener/internal/logger"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
+// clicksFileSuffix - переходы пишутся рядом с основным файлом, в отдельный журнал
+const clicksFileSuffix = ".clicks"
+
 type FileStorage struct {
-	file *os.File
-	data map[string]string
-	mu   sync.RWMutex
+	path       string
+	file       *os.File
+	clicksFile *os.File
+	data       map[string]storedRecord
+	longURLs   map[string]string
+	userURLs   map[string][]string
+	clicks     map[string]*ClickStats
+	mu         sync.RWMutex
 }
 
 func NewFileStorage(path string) (*FileStorage, error) {
@@ -22,17 +33,35 @@ func NewFileStorage(path string) (*FileStorage, error) {
 	if err != nil {
 		return nil, err
 	}
+	clicksFile, err := os.OpenFile(path+clicksFileSuffix, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
 
 	fs := &FileStorage{
-		file: file,
-		data: make(map[string]string),
+		path:       path,
+		file:       file,
+		clicksFile: clicksFile,
+		data:       make(map[string]storedRecord),
+		longURLs:   make(map[string]string),
+		userURLs:   make(map[string][]string),
+		clicks:     make(map[string]*ClickStats),
 	}
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		var rec storedRecord
 		if err := json.Unmarshal(scanner.Bytes(), &rec); err == nil {
-			fs.data[rec.ShortURL] = rec.OriginalURL
+			fs.put(rec)
+		}
+	}
+
+	clicksScanner := bufio.NewScanner(clicksFile)
+	for clicksScanner.Scan() {
+		var ev ClickEvent
+		if err := json.Unmarshal(clicksScanner.Bytes(), &ev); err == nil {
+			addClick(fs.clicks, ev)
 		}
 	}
 
@@ -46,7 +75,7 @@ func (f *FileStorage) Ping(_ context.Context) error {
 	return fmt.Errorf("file repository is empty")
 }
 
-func (f *FileStorage) Save(ctx context.Context, short string, long string) (isDouble bool, err error) {
+func (f *FileStorage) Save(ctx context.Context, rec URLRecord) (isDouble bool, err error) {
 	select {
 	case <-ctx.Done():
 		logger.Sugaarz.Info("Client closed connection while in url Save func")
@@ -57,15 +86,17 @@ func (f *FileStorage) Save(ctx context.Context, short string, long string) (isDo
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if _, exists := f.data[short]; exists {
+	if _, live := f.liveShort(rec.OriginalURL, time.Now()); live {
 		return true, nil
 	}
+	if _, exists := f.data[rec.ShortURL]; exists {
+		return false, ErrShortURLTaken
+	}
 
-	f.data[short] = long
-
-	rec := newStoredRecord(short, long)
+	stored := newStoredRecord(rec)
+	f.put(stored)
 
-	b, err := json.Marshal(rec)
+	b, err := json.Marshal(stored)
 	if err != nil {
 		return false, err
 	}
@@ -74,31 +105,251 @@ func (f *FileStorage) Save(ctx context.Context, short string, long string) (isDo
 	return false, err
 }
 
-func (f *FileStorage) Get(_ context.Context, short string) (string, error) {
+func (f *FileStorage) Get(_ context.Context, short string) (URLRecord, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
-	val, exists := f.data[short]
+	rec, exists := f.data[short]
 	if !exists {
-		return "", ErrURLNotFound
+		return URLRecord{}, ErrURLNotFound
+	}
+	if rec.IsDeleted {
+		return URLRecord{}, ErrURLDeleted
+	}
+	return rec.URLRecord, nil
+}
+
+// GetByOriginalURL ищет живую ссылку по исходному url, удаленные и истекшие url за собой не держат
+func (f *FileStorage) GetByOriginalURL(_ context.Context, longURL string) (URLRecord, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	short, live := f.liveShort(longURL, time.Now())
+	if !live {
+		return URLRecord{}, ErrURLNotFound
+	}
+	return f.data[short].URLRecord, nil
+}
+
+// liveShort - id ссылки на url, если она жива, вызывающий должен держать блокировку
+func (f *FileStorage) liveShort(longURL string, now time.Time) (string, bool) {
+	short, exists := f.longURLs[longURL]
+	return short, exists && f.data[short].IsLive(now)
+}
+
+func (f *FileStorage) GetUserURLs(_ context.Context, userID string) ([]URLRecord, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	now := time.Now()
+	shorts := f.userURLs[userID]
+	urls := make([]URLRecord, 0, len(shorts))
+	for _, short := range shorts {
+		if rec := f.data[short]; !rec.IsDeleted && !rec.IsExpired(now) {
+			urls = append(urls, rec.URLRecord)
+		}
+	}
+	return urls, nil
+}
+
+// FindUserURLs - GetUserURLs с фильтрами, сортировкой и постраничной выдачей
+func (f *FileStorage) FindUserURLs(ctx context.Context, q URLQuery) ([]URLRecord, error) {
+	urls, err := f.GetUserURLs(ctx, q.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return queryURLs(urls, q), nil
+}
+
+func (f *FileStorage) GetStats(_ context.Context) (ServiceStats, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var stats ServiceStats
+	for _, rec := range f.data {
+		if !rec.IsDeleted {
+			stats.URLs++
+		}
+	}
+	for _, shorts := range f.userURLs {
+		if len(shorts) > 0 {
+			stats.Users++
+		}
+	}
+	return stats, nil
+}
+
+// Update дописывает в файл новую версию записи, при загрузке она перекроет прежнюю
+func (f *FileStorage) Update(_ context.Context, short, userID string, patch URLPatch) (URLRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec, exists := f.data[short]
+	if !exists || rec.IsDeleted || rec.UserID != userID {
+		return URLRecord{}, ErrURLNotFound
+	}
+	oldURL := rec.OriginalURL
+	patch.apply(&rec.URLRecord)
+	// как и в памяти, занятость url проверяется только при его смене: истекшая ссылка, чей url
+	// сокращен заново, все равно должна редактироваться
+	if rec.OriginalURL != oldURL {
+		if _, taken := f.liveShort(rec.OriginalURL, time.Now()); taken {
+			return URLRecord{}, ErrLongURLTaken
+		}
+	}
+	rec.UUID = uuid.New().String()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return URLRecord{}, err
+	}
+	if _, err := f.file.Write(append(b, '\n')); err != nil {
+		return URLRecord{}, fmt.Errorf("error while updating url: %w: %v", ErrUpdateURL, err)
 	}
-	return val, nil
+	f.put(rec)
+	return rec.URLRecord, nil
+}
+
+// DeleteURLs дописывает в файл обновленные записи, при загрузке последняя запись побеждает
+func (f *FileStorage) DeleteURLs(_ context.Context, batch []DeleteRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var buf []byte
+	for _, req := range batch {
+		rec, exists := f.data[req.URLHash]
+		if !exists || rec.UserID != req.UserID || rec.IsDeleted {
+			continue
+		}
+		rec.UUID = uuid.New().String()
+		rec.IsDeleted = true
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, append(b, '\n')...)
+		f.put(rec)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := f.file.Write(buf)
+	return err
+}
+
+// PurgeExpired помечает истекшие ссылки удаленными, как и бд, и схлопывает файл. До перезапуска
+// по ним отдается 410, а в новый файл они уже не попадают, и он не растет от истекших ссылок
+func (f *FileStorage) PurgeExpired(_ context.Context, now time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var purged int64
+	for short, rec := range f.data {
+		if !rec.IsDeleted && rec.IsExpired(now) {
+			rec.IsDeleted = true
+			f.data[short] = rec
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := f.compact(now); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// compact атомарно заменяет файл текущим состоянием без истекших к now ссылок,
+// вызывающий должен держать блокировку
+func (f *FileStorage) compact(now time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error while compacting file storage: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range f.data {
+		if rec.IsExpired(now) {
+			continue
+		}
+		if err := enc.Encode(rec); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("error while compacting file storage: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error while compacting file storage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error while compacting file storage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("error while compacting file storage: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error while reopening file storage: %w", err)
+	}
+	_ = f.file.Close()
+	f.file = file
+	return nil
+}
+
+// put обновляет индексы в памяти, вызывающий должен держать блокировку
+func (f *FileStorage) put(rec storedRecord) {
+	prev, exists := f.data[rec.ShortURL]
+	if !exists && rec.UserID != "" {
+		f.userURLs[rec.UserID] = append(f.userURLs[rec.UserID], rec.ShortURL)
+	}
+	if exists && prev.OriginalURL != rec.OriginalURL && f.longURLs[prev.OriginalURL] == rec.ShortURL {
+		delete(f.longURLs, prev.OriginalURL)
+	}
+	f.data[rec.ShortURL] = rec
+	// url переходит к новой ссылке, если прежняя удалена или истекла
+	if _, taken := f.liveShort(rec.OriginalURL, time.Now()); !taken {
+		f.longURLs[rec.OriginalURL] = rec.ShortURL
+	}
+}
+
+func (f *FileStorage) SaveClicks(_ context.Context, events []ClickEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var buf []byte
+	for _, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, append(b, '\n')...)
+		addClick(f.clicks, ev)
+	}
+	_, err := f.clicksFile.Write(buf)
+	return err
+}
+
+func (f *FileStorage) GetClickStats(_ context.Context, short string) (ClickStats, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.clicks[short].copy(), nil
 }
 
 func (f *FileStorage) Close() error {
-	return f.file.Close()
+	return errors.Join(f.file.Close(), f.clicksFile.Close())
 }
 
 type storedRecord struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	UUID string `json:"uuid"`
+	URLRecord
 }
 
-func newStoredRecord(shortURL, originalURL string) storedRecord {
+func newStoredRecord(rec URLRecord) storedRecord {
 	return storedRecord{
-		UUID:        uuid.New().String(),
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
+		UUID:      uuid.New().String(),
+		URLRecord: rec,
 	}
 }
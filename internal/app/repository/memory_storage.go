@@ -4,15 +4,25 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 )
 
 type InMemoryRepository struct {
-	data map[string]string
-	mu   sync.RWMutex
+	data map[string]URLRecord
+	// longURLs - индекс url -> id, url уникален так же, как в бд
+	longURLs map[string]string
+	userURLs map[string][]string
+	clicks   map[string]*ClickStats
+	mu       sync.RWMutex
 }
 
 func NewInMemoryRepository() *InMemoryRepository {
-	return &InMemoryRepository{data: make(map[string]string)}
+	return &InMemoryRepository{
+		data:     make(map[string]URLRecord),
+		longURLs: make(map[string]string),
+		userURLs: make(map[string][]string),
+		clicks:   make(map[string]*ClickStats),
+	}
 }
 
 func (s *InMemoryRepository) Ping(_ context.Context) error {
@@ -22,21 +32,168 @@ func (s *InMemoryRepository) Ping(_ context.Context) error {
 	return fmt.Errorf("in memory repository is empty")
 }
 
-func (s *InMemoryRepository) Save(_ context.Context, short string, long string) (isDouble bool, err error) {
+func (s *InMemoryRepository) Save(_ context.Context, rec URLRecord) (isDouble bool, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[short] = long
+	if _, live := s.liveShort(rec.OriginalURL, time.Now()); live {
+		return true, nil
+	}
+	if _, exists := s.data[rec.ShortURL]; exists {
+		return false, ErrShortURLTaken
+	}
+	s.data[rec.ShortURL] = rec
+	s.longURLs[rec.OriginalURL] = rec.ShortURL
+	if rec.UserID != "" {
+		s.userURLs[rec.UserID] = append(s.userURLs[rec.UserID], rec.ShortURL)
+	}
 	return false, nil
 }
 
-func (s *InMemoryRepository) Get(_ context.Context, short string) (string, error) {
+func (s *InMemoryRepository) Get(_ context.Context, short string) (URLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, exists := s.data[short]
+	if !exists {
+		return URLRecord{}, ErrURLNotFound
+	}
+	if rec.IsDeleted {
+		return URLRecord{}, ErrURLDeleted
+	}
+	return rec, nil
+}
+
+// GetByOriginalURL ищет живую ссылку по исходному url, удаленные и истекшие url за собой не держат
+func (s *InMemoryRepository) GetByOriginalURL(_ context.Context, longURL string) (URLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	short, live := s.liveShort(longURL, time.Now())
+	if !live {
+		return URLRecord{}, ErrURLNotFound
+	}
+	return s.data[short], nil
+}
+
+// liveShort - id ссылки на url, если она жива, вызывающий должен держать блокировку
+func (s *InMemoryRepository) liveShort(longURL string, now time.Time) (string, bool) {
+	short, exists := s.longURLs[longURL]
+	return short, exists && s.data[short].IsLive(now)
+}
+
+func (s *InMemoryRepository) GetUserURLs(_ context.Context, userID string) ([]URLRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	shorts := s.userURLs[userID]
+	urls := make([]URLRecord, 0, len(shorts))
+	for _, short := range shorts {
+		if rec := s.data[short]; !rec.IsDeleted && !rec.IsExpired(now) {
+			urls = append(urls, rec)
+		}
+	}
+	return urls, nil
+}
+
+// FindUserURLs - GetUserURLs с фильтрами, сортировкой и постраничной выдачей
+func (s *InMemoryRepository) FindUserURLs(ctx context.Context, q URLQuery) ([]URLRecord, error) {
+	urls, err := s.GetUserURLs(ctx, q.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return queryURLs(urls, q), nil
+}
+
+func (s *InMemoryRepository) GetStats(_ context.Context) (ServiceStats, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	val, exists := s.data[short]
+	var stats ServiceStats
+	for _, rec := range s.data {
+		if !rec.IsDeleted {
+			stats.URLs++
+		}
+	}
+	for _, shorts := range s.userURLs {
+		if len(shorts) > 0 {
+			stats.Users++
+		}
+	}
+	return stats, nil
+}
+
+func (s *InMemoryRepository) Update(_ context.Context, short, userID string, patch URLPatch) (URLRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, exists := s.data[short]
+	if !exists || rec.IsDeleted || rec.UserID != userID {
+		return URLRecord{}, ErrURLNotFound
+	}
+	oldURL := rec.OriginalURL
+	patch.apply(&rec)
+	if rec.OriginalURL != oldURL {
+		if _, taken := s.liveShort(rec.OriginalURL, time.Now()); taken {
+			return URLRecord{}, ErrLongURLTaken
+		}
+		if s.longURLs[oldURL] == short {
+			delete(s.longURLs, oldURL)
+		}
+		s.longURLs[rec.OriginalURL] = short
+	}
+	s.data[short] = rec
+	return rec, nil
+}
+
+func (s *InMemoryRepository) DeleteURLs(_ context.Context, batch []DeleteRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, req := range batch {
+		rec, exists := s.data[req.URLHash]
+		if !exists || rec.UserID != req.UserID {
+			continue
+		}
+		rec.IsDeleted = true
+		s.data[req.URLHash] = rec
+	}
+	return nil
+}
+
+// PurgeExpired помечает истекшие ссылки удаленными, как и бд: по ним продолжает отдаваться 410,
+// а их id не достается новым ссылкам
+func (s *InMemoryRepository) PurgeExpired(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for short, rec := range s.data {
+		if !rec.IsDeleted && rec.IsExpired(now) {
+			rec.IsDeleted = true
+			s.data[short] = rec
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// SaveClicks хранит только агрегаты, сырые события в памяти не нужны
+func (s *InMemoryRepository) SaveClicks(_ context.Context, events []ClickEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range events {
+		addClick(s.clicks, ev)
+	}
+	return nil
+}
+
+func (s *InMemoryRepository) GetClickStats(_ context.Context, short string) (ClickStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clicks[short].copy(), nil
+}
+
+func addClick(clicks map[string]*ClickStats, ev ClickEvent) {
+	stats, exists := clicks[ev.ShortURL]
 	if !exists {
-		return "", ErrURLNotFound
+		stats = newClickStats()
+		clicks[ev.ShortURL] = stats
 	}
-	return val, nil
+	stats.add(ev)
 }
 
 func (s *InMemoryRepository) Close() error { return nil }
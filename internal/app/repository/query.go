@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// SortField - поле, по которому сортируется список ссылок пользователя
+type SortField string
+
+const (
+	SortCreatedAt   SortField = "created_at"
+	SortOriginalURL SortField = "original_url"
+	SortTitle       SortField = "title"
+)
+
+// URLQuery - выборка ссылок пользователя, пустые поля выборку не ограничивают
+type URLQuery struct {
+	UserID string
+	// Tags - у ссылки должны быть все перечисленные теги
+	Tags []string
+	// Search - подстрока url, заголовка или id без учета регистра
+	Search string
+	Sort   SortField
+	Desc   bool
+	// After - выдача продолжается со следующей за курсором записи
+	After *Cursor
+	// Limit 0 - без ограничения
+	Limit int
+}
+
+// Cursor - позиция в выдаче: значение поля сортировки и id записи, id делает порядок однозначным
+type Cursor struct {
+	Key      string
+	ShortURL string
+}
+
+// CursorOf возвращает курсор, указывающий на rec в порядке этой выборки
+func (q URLQuery) CursorOf(rec URLRecord) Cursor {
+	c := Cursor{ShortURL: rec.ShortURL}
+	switch q.Sort {
+	case SortOriginalURL:
+		c.Key = rec.OriginalURL
+	case SortTitle:
+		c.Key = rec.Title
+	default:
+		c.Key = rec.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return c
+}
+
+// cursorRecord - запись, стоящая на месте курсора, чтобы сравнивать ее как обычную
+func (q URLQuery) cursorRecord(c Cursor) URLRecord {
+	rec := URLRecord{ShortURL: c.ShortURL}
+	switch q.Sort {
+	case SortOriginalURL:
+		rec.OriginalURL = c.Key
+	case SortTitle:
+		rec.Title = c.Key
+	default:
+		rec.CreatedAt, _ = time.Parse(time.RFC3339Nano, c.Key)
+	}
+	return rec
+}
+
+func (q URLQuery) compare(a, b URLRecord) int {
+	var c int
+	switch q.Sort {
+	case SortOriginalURL:
+		c = strings.Compare(a.OriginalURL, b.OriginalURL)
+	case SortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ShortURL, b.ShortURL)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+func (q URLQuery) matches(rec URLRecord, search string) bool {
+	if !rec.HasTags(q.Tags) {
+		return false
+	}
+	if search == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(rec.OriginalURL), search) ||
+		strings.Contains(strings.ToLower(rec.Title), search) ||
+		strings.Contains(strings.ToLower(rec.ShortURL), search)
+}
+
+// queryURLs выполняет выборку над записями в памяти так же, как ее выполняет запрос к бд
+func queryURLs(urls []URLRecord, q URLQuery) []URLRecord {
+	search := strings.ToLower(q.Search)
+	var after URLRecord
+	if q.After != nil {
+		after = q.cursorRecord(*q.After)
+	}
+	found := make([]URLRecord, 0, len(urls))
+	for _, rec := range urls {
+		if !q.matches(rec, search) {
+			continue
+		}
+		if q.After != nil && q.compare(rec, after) <= 0 {
+			continue
+		}
+		found = append(found, rec)
+	}
+	slices.SortFunc(found, q.compare)
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found
+}
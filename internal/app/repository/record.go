@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"slices"
+	"time"
+)
+
+// URLRecord - сохраненная короткая ссылка со всеми ее атрибутами
+// теги json задают формат строк в файловом хранилище
+type URLRecord struct {
+	ShortURL    string     `db:"short_url" json:"short_url"`
+	OriginalURL string     `db:"long_url" json:"original_url"`
+	UserID      string     `db:"user_id" json:"user_id,omitempty"`
+	IsDeleted   bool       `db:"is_deleted" json:"is_deleted,omitempty"`
+	ExpiresAt   *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at,omitzero"`
+	// PasswordHash - bcrypt-хеш пароля, пустой у открытых ссылок
+	PasswordHash string `db:"password_hash" json:"password_hash,omitempty"`
+	// RedirectCode - код ответа при переходе, 0 - код по умолчанию из конфига
+	RedirectCode int    `db:"redirect_code" json:"redirect_code,omitempty"`
+	Title        string `db:"title" json:"title,omitempty"`
+	// Tags в бд лежат в отдельной таблице url_tags, отсортированы
+	Tags Tags `db:"tags" json:"tags,omitempty"`
+	// Passthrough - режим передачи пути и параметров запроса в адрес назначения, пустой - выключено
+	Passthrough string `db:"passthrough" json:"passthrough,omitempty"`
+	// Targets - правила выбора адреса по устройству, проверяются по порядку
+	Targets TargetRules `db:"targets" json:"targets,omitempty"`
+	// Variants - адреса A/B-сплита с весами, если заданы, то заменяют OriginalURL при переходе
+	Variants Variants `db:"variants" json:"variants,omitempty"`
+	// StickyVariants - посетитель закрепляется за выпавшим вариантом через куку
+	StickyVariants bool `db:"sticky_variants" json:"sticky_variants,omitempty"`
+}
+
+// Variant - один из адресов сплита, Name попадает в статистику переходов и в куку посетителя
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// TargetRule ведет на URL клиентов с подходящими OS и Device, пустое условие подходит всем
+type TargetRule struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	URL    string `json:"url"`
+}
+
+// BatchResult - итог сохранения одной записи пакета
+type BatchResult struct {
+	// ShortURL - id, под которым url лежит в хранилище, у уже сокращенного url он может отличаться от запрошенного
+	ShortURL string
+	Created  bool
+	// Err - ErrShortURLTaken, если запрошенный id занят другим url
+	Err error
+}
+
+// URLPatch - изменяемые поля ссылки, nil оставляет поле как есть
+type URLPatch struct {
+	OriginalURL  *string
+	RedirectCode *int
+	Title        *string
+	// Tags заменяет теги целиком, пустой срез удаляет все
+	Tags        *[]string
+	Passthrough *string
+	// Targets заменяет правила целиком, пустой срез удаляет все
+	Targets *TargetRules
+	// Variants заменяет варианты целиком, пустой срез выключает сплит
+	Variants       *Variants
+	StickyVariants *bool
+}
+
+func (p URLPatch) apply(rec *URLRecord) {
+	if p.OriginalURL != nil {
+		rec.OriginalURL = *p.OriginalURL
+	}
+	if p.RedirectCode != nil {
+		rec.RedirectCode = *p.RedirectCode
+	}
+	if p.Title != nil {
+		rec.Title = *p.Title
+	}
+	if p.Tags != nil {
+		rec.Tags = *p.Tags
+	}
+	if p.Passthrough != nil {
+		rec.Passthrough = *p.Passthrough
+	}
+	if p.Targets != nil {
+		rec.Targets = *p.Targets
+	}
+	if p.Variants != nil {
+		rec.Variants = *p.Variants
+	}
+	if p.StickyVariants != nil {
+		rec.StickyVariants = *p.StickyVariants
+	}
+}
+
+// HasTags - у ссылки есть все перечисленные теги
+func (r URLRecord) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(r.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r URLRecord) IsProtected() bool {
+	return r.PasswordHash != ""
+}
+
+func (r URLRecord) IsExpired(now time.Time) bool {
+	return r.ExpiresAt != nil && !r.ExpiresAt.After(now)
+}
+
+// IsLive - ссылка не удалена и не истекла. Только живая ссылка держит за собой url:
+// повторное сокращение url удаленной или истекшей ссылки создает новую
+func (r URLRecord) IsLive(now time.Time) bool {
+	return !r.IsDeleted && !r.IsExpired(now)
+}
+
+// ServiceStats - сводные счетчики по всему хранилищу
+type ServiceStats struct {
+	URLs  int `db:"urls"`
+	Users int `db:"users"`
+}
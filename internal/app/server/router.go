@@ -3,24 +3,62 @@ package server
 import (
 	"github.com/stlesnik/url_shortener/internal/app/handlers"
 	"github.com/stlesnik/url_shortener/internal/app/middleware"
-	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/app/openapi"
+	"github.com/stlesnik/url_shortener/internal/logger"
 	"net/http"
 )
 
 func (s *Server) setupRoutes() {
-	service := services.New(s.repo, s.cfg)
-	hs := handlers.New(service)
+	hs := handlers.New(s.service)
+	auth := middleware.NewAuthenticator(s.cfg.SecretKey)
+	trusted, err := middleware.NewTrustedSubnet(s.cfg.TrustedSubnet)
+	if err != nil {
+		logger.Sugaarz.Errorw("internal endpoints are closed", "err", err)
+	}
+	proxies, err := middleware.NewTrustedProxies(s.cfg.TrustedProxies)
+	if err != nil {
+		logger.Sugaarz.Errorw("proxy headers are ignored", "err", err)
+	}
+	validate := func(h http.HandlerFunc) http.HandlerFunc { return h }
+	if s.cfg.ValidateRequests {
+		if doc, err := openapi.Load(); err != nil {
+			logger.Sugaarz.Errorw("request validation is disabled", "err", err)
+		} else {
+			validate = middleware.NewRequestValidator(doc).WithValidation
+		}
+	}
 	wrap := func(h http.HandlerFunc) http.HandlerFunc {
-		return middleware.WithLogging(
-			middleware.WithDecompress(
-				middleware.WithCompress(h),
-			),
+		return middleware.WithRequestID(
+			proxies.WithClientIP(middleware.WithLogging(
+				auth.WithAuth(
+					middleware.WithDecompress(
+						middleware.WithCompress(validate(h)),
+					),
+				),
+			)),
 		)
 	}
 	s.router.Post("/", wrap(hs.SaveURL))
 	s.router.Get("/ping", wrap(hs.PingDB))
 	s.router.Get("/{id}", wrap(hs.GetLongURL))
+	s.router.Post("/{id}", wrap(hs.GetLongURL))
+	s.router.Get("/{id}/qr", wrap(hs.GetQRCode))
+	// остаток пути нужен ссылкам с передачей пути, /{id}/qr как точный маршрут важнее
+	s.router.Get("/{id}/*", wrap(hs.GetLongURL))
+	s.router.Post("/{id}/*", wrap(hs.GetLongURL))
 	s.router.Post("/api/shorten", wrap(hs.APIPrepareShortURL))
 	s.router.Post("/api/shorten/batch", wrap(hs.APIPrepareBatchShortURL))
+	s.router.Post("/api/shorten/stream", wrap(hs.APIPrepareStreamShortURL))
+	s.router.Patch("/api/shorten/{id}", wrap(hs.UpdateShortURL))
+	s.router.Get("/api/expand", wrap(hs.ExpandShortURL))
+	s.router.Post("/api/expand/batch", wrap(hs.ExpandBatch))
+	s.router.Get("/api/user/urls", wrap(hs.GetUserURLs))
+	s.router.Delete("/api/user/urls", wrap(hs.DeleteUserURLs))
+	s.router.Post("/api/import", wrap(hs.ImportURLs))
+	s.router.Get("/api/export", wrap(hs.ExportUserURLs))
+	s.router.Get("/api/stats/{id}", wrap(hs.GetLinkStats))
+	s.router.Get("/api/internal/stats", wrap(trusted.WithTrustedSubnet(hs.GetInternalStats)))
+	s.router.Get("/api/openapi.json", wrap(hs.GetOpenAPISpec))
+	s.router.Get("/api/docs", wrap(hs.GetAPIDocs))
 
 }
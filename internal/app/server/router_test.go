@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/stlesnik/url_shortener/internal/app/openapi"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/app/services"
+	"github.com/stlesnik/url_shortener/internal/config"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// TestRoutesDocumented не дает добавить роут, забыв описать его в спецификации
+func TestRoutesDocumented(t *testing.T) {
+	require.NoError(t, logger.InitLogger("dev"))
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	service := services.New(repository.NewInMemoryRepository(), cfg)
+	defer service.Close()
+	s := New(service, cfg)
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+
+	err = chi.Walk(s.router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		path := doc.Paths.Value(route)
+		if assert.NotNil(t, path, "route %s is not documented", route) {
+			assert.NotNil(t, path.GetOperation(method), "route %s %s is not documented", method, route)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"github.com/go-chi/chi/v5"
 	"github.com/stlesnik/url_shortener/internal/app/services"
 	"github.com/stlesnik/url_shortener/internal/config"
@@ -8,21 +9,29 @@ import (
 )
 
 type Server struct {
-	router chi.Router
-	repo   services.Repository
-	cfg    *config.Config
+	router  chi.Router
+	service *services.URLShortenerService
+	cfg     *config.Config
+	httpSrv *http.Server
 }
 
-func New(repo services.Repository, cfg *config.Config) *Server {
+// New принимает уже созданный сервис: он общий с gRPC-сервером
+func New(service *services.URLShortenerService, cfg *config.Config) *Server {
 	s := &Server{
-		router: chi.NewRouter(),
-		repo:   repo,
-		cfg:    cfg,
+		router:  chi.NewRouter(),
+		service: service,
+		cfg:     cfg,
 	}
 	s.setupRoutes()
+	s.httpSrv = &http.Server{Addr: cfg.ServerAddress, Handler: s.router}
 	return s
 }
 
 func (s *Server) Start() error {
-	return http.ListenAndServe(s.cfg.ServerAddress, s.router)
+	return s.httpSrv.ListenAndServe()
+}
+
+// Shutdown останавливает прием запросов, фоновые задачи сервиса останавливает владелец сервиса
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpSrv.Shutdown(ctx)
 }
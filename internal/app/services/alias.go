@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"regexp"
+	"strings"
+)
+
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 64
+)
+
+// в алиасе нет "=", поэтому он никогда не совпадет с хешем из CreateShortURLHash
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// IsAlias отличает пользовательский id от хеша: в хеше всегда есть "="
+func IsAlias(id string) bool {
+	return aliasPattern.MatchString(id)
+}
+
+func (s *URLShortenerService) ValidateAlias(alias string) error {
+	if len(alias) < aliasMinLength || len(alias) > aliasMaxLength {
+		return fmt.Errorf("alias length must be from %d to %d: %w", aliasMinLength, aliasMaxLength, ErrAliasInvalid)
+	}
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("alias may contain only latin letters, digits, '-' and '_': %w", ErrAliasInvalid)
+	}
+	for _, reserved := range s.cfg.ReservedAliases {
+		if strings.EqualFold(alias, strings.TrimSpace(reserved)) {
+			return fmt.Errorf("alias %q is reserved: %w", alias, ErrAliasReserved)
+		}
+	}
+	return nil
+}
+
+// CreateSavePrepareAliasURL сохраняет url под выбранным пользователем алиасом
+func (s *URLShortenerService) CreateSavePrepareAliasURL(ctx context.Context, longURL, alias string, opts ShortenOptions) (string, bool, error) {
+	if err := s.ValidateAlias(alias); err != nil {
+		return "", false, err
+	}
+	longURL = s.CanonicalURL(longURL)
+	isDouble, err := s.SaveShortURL(ctx, alias, longURL, opts)
+	if errors.Is(err, repository.ErrShortURLTaken) {
+		return "", false, fmt.Errorf("alias %q: %w", alias, ErrAliasTaken)
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to save short url: %w", err)
+	}
+	if isDouble {
+		// в бд url уникален, и конфликт мог случиться на уже сокращенном под другим id url
+		saved, getErr := s.repo.Get(ctx, alias)
+		if getErr != nil || saved.OriginalURL != longURL {
+			return "", false, fmt.Errorf("url %q: %w", longURL, ErrURLAlreadyShortened)
+		}
+	}
+	return s.PrepareShortURL(alias), isDouble, nil
+}
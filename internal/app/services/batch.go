@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/logger"
+)
+
+type BatchStatus string
+
+const (
+	BatchCreated  BatchStatus = "created"
+	BatchExisting BatchStatus = "existing"
+	BatchInvalid  BatchStatus = "invalid"
+)
+
+// BatchEntry - элемент пакетного сокращения. Элементы с уже заполненной Err
+// не сохраняются, остальным SaveBatchShortURL проставляет ShortURL и Status
+type BatchEntry struct {
+	LongURL string
+	// Alias - необязательный пользовательский id, такой элемент сохраняется отдельно
+	Alias    string
+	Opts     ShortenOptions
+	ShortURL string
+	Status   BatchStatus
+	Err      error
+}
+
+// Invalidate помечает элемент непрошедшим проверку
+func (e *BatchEntry) Invalidate(err error) {
+	e.Status = BatchInvalid
+	e.Err = err
+}
+
+// NewBatchEntry проверяет url и параметры элемента, непрошедший проверку сразу помечается invalid.
+// LongURL прошедшего проверку элемента приводится к каноническому виду
+func (s *URLShortenerService) NewBatchEntry(longURL, userID string, anonymous bool, p LinkParams) BatchEntry {
+	entry := BatchEntry{LongURL: longURL}
+	if err := s.ValidateURL(longURL); err != nil {
+		entry.Invalidate(err)
+		return entry
+	}
+	entry.LongURL = s.CanonicalURL(longURL)
+	opts, err := s.NewShortenOptions(userID, anonymous, p)
+	if err != nil {
+		entry.Invalidate(err)
+		return entry
+	}
+	entry.Opts = opts
+	return entry
+}
+
+// SaveBatchShortURL сохраняет пакет и раскладывает итог по элементам.
+// Ошибка возвращается, только если хранилище не смогло обработать пакет целиком
+func (s *URLShortenerService) SaveBatchShortURL(ctx context.Context, entries []BatchEntry) error {
+	var pending []int
+	for i := range entries {
+		entry := &entries[i]
+		switch {
+		case entry.Err != nil:
+			entry.Status = BatchInvalid
+		case entry.Alias != "":
+			shortURL, isDouble, err := s.CreateSavePrepareAliasURL(ctx, entry.LongURL, entry.Alias, entry.Opts)
+			if isEntryError(err) {
+				entry.Invalidate(err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			entry.ShortURL, entry.Status = shortURL, BatchCreated
+			if isDouble {
+				entry.Status = BatchExisting
+			}
+		case entry.Opts.PasswordHash != "":
+			// защищенную ссылку нельзя молча слить с существующей, поэтому сохраняем ее отдельно
+			shortURL, _, err := s.CreateShortURL(ctx, entry.LongURL, entry.Opts)
+			if isEntryError(err) {
+				entry.Invalidate(err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			entry.ShortURL, entry.Status = shortURL, BatchCreated
+		default:
+			pending = append(pending, i)
+		}
+	}
+
+	for attempt := 0; attempt < maxHashAttempts && len(pending) > 0; attempt++ {
+		records := make([]repository.URLRecord, 0, len(pending))
+		for _, i := range pending {
+			urlHash, err := shortURLHash(entries[i].LongURL, attempt)
+			if err != nil {
+				return fmt.Errorf("failed to create short url: %w", err)
+			}
+			records = append(records, entries[i].Opts.Record(urlHash, entries[i].LongURL))
+		}
+		results, err := s.saveBatch(ctx, records)
+		if err != nil {
+			return err
+		}
+
+		var retry []int
+		for j, i := range pending {
+			res := results[j]
+			if errors.Is(res.Err, repository.ErrShortURLTaken) {
+				retry = append(retry, i)
+				continue
+			}
+			entries[i].ShortURL = s.PrepareShortURL(res.ShortURL)
+			entries[i].Status = BatchExisting
+			if res.Created {
+				entries[i].Status = BatchCreated
+			}
+		}
+		pending = retry
+	}
+	for _, i := range pending {
+		entries[i].Invalidate(fmt.Errorf("no free short url after %d attempts", maxHashAttempts))
+	}
+	return nil
+}
+
+// isEntryError - ошибка касается только самого элемента, а не всего пакета
+func isEntryError(err error) bool {
+	return errors.Is(err, ErrAliasInvalid) || errors.Is(err, ErrAliasReserved) ||
+		errors.Is(err, ErrAliasTaken) || errors.Is(err, ErrURLAlreadyShortened)
+}
+
+func (s *URLShortenerService) saveBatch(ctx context.Context, records []repository.URLRecord) ([]repository.BatchResult, error) {
+	if bSaver, ok := s.repo.(BatchSaver); ok {
+		logger.Sugaarz.Debugw("saving batch urls with BatchSaver")
+		return bSaver.SaveBatch(ctx, records)
+	}
+
+	logger.Sugaarz.Debugw("saving batch urls ordinary way")
+	results := make([]repository.BatchResult, 0, len(records))
+	for _, rec := range records {
+		isDouble, err := s.repo.Save(ctx, rec)
+		if errors.Is(err, repository.ErrShortURLTaken) {
+			results = append(results, repository.BatchResult{Err: err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		short := rec.ShortURL
+		if isDouble {
+			if short, err = s.existingShortURL(ctx, rec.OriginalURL, rec.ShortURL); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, repository.BatchResult{ShortURL: short, Created: !isDouble})
+	}
+	return results, nil
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hashPrefixMinLength - 4 байта, как у коротких префиксов Safe Browsing
+	hashPrefixMinLength = 8
+	// как в Safe Browsing: не больше 5 хостов и 6 путей на url
+	maxHostExpressions = 5
+	maxPathExpressions = 6
+)
+
+// blocklist - разобранный файл блок-листа, после загрузки не меняется
+type blocklist struct {
+	domains  map[string]bool
+	prefixes prefixIndex
+	hashes   prefixIndex
+}
+
+// prefixIndex - отсортированные префиксы без тех, что продолжают более короткий. В таком списке
+// префиксом строки может быть только ближайший к ней снизу элемент, его находит двоичный поиск
+type prefixIndex []string
+
+func newPrefixIndex(prefixes []string) prefixIndex {
+	slices.Sort(prefixes)
+	idx := make(prefixIndex, 0, len(prefixes))
+	for _, p := range prefixes {
+		if len(idx) > 0 && strings.HasPrefix(p, idx[len(idx)-1]) {
+			continue
+		}
+		idx = append(idx, p)
+	}
+	return idx
+}
+
+// match возвращает префикс s из списка
+func (idx prefixIndex) match(s string) (string, bool) {
+	i, found := slices.BinarySearch(idx, s)
+	if found {
+		return s, true
+	}
+	if i > 0 && strings.HasPrefix(s, idx[i-1]) {
+		return idx[i-1], true
+	}
+	return "", false
+}
+
+// BlocklistChecker - URLChecker по локальному файлу. Строки файла:
+//
+//	# комментарий
+//	domain:evil.com                  - домен и все его поддомены
+//	prefix:https://host.ru/phishing/ - url, начинающиеся с префикса
+//	hash:1a2b3c4d                    - hex-префикс SHA-256 выражения host/path, как в Safe Browsing
+//
+// Watch перечитывает файл при изменении, при ошибке разбора остается прежний список
+type BlocklistChecker struct {
+	path    string
+	list    atomic.Pointer[blocklist]
+	modTime time.Time
+	size    int64
+}
+
+func NewBlocklistChecker(path string) (*BlocklistChecker, error) {
+	c := &BlocklistChecker{path: path}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Watch проверяет файл раз в interval и блокируется до отмены контекста
+func (c *BlocklistChecker) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		logger.Sugaarz.Infow("blocklist reload is disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.reload()
+			if err != nil {
+				logger.Sugaarz.Errorw("failed to reload blocklist, keeping previous one", "path", c.path, "err", err)
+				continue
+			}
+			if reloaded {
+				logger.Sugaarz.Infow("blocklist reloaded", "path", c.path)
+			}
+		}
+	}
+}
+
+// reload перечитывает файл, если у него поменялись время изменения или размер.
+// Watch - единственный вызывающий после конструктора, поэтому modTime и size без блокировок
+func (c *BlocklistChecker) reload() (bool, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return false, fmt.Errorf("can not stat blocklist: %w", err)
+	}
+	if c.list.Load() != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return false, nil
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return false, fmt.Errorf("can not open blocklist: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	list, err := parseBlocklist(f)
+	if err != nil {
+		return false, fmt.Errorf("can not parse blocklist %s: %w", c.path, err)
+	}
+	c.list.Store(list)
+	c.modTime, c.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+func parseBlocklist(r io.Reader) (*blocklist, error) {
+	list := &blocklist{domains: make(map[string]bool)}
+	var prefixes, hashes []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, value, found := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		if !found || value == "" {
+			return nil, fmt.Errorf("line %d: want kind:value, got %q", n, line)
+		}
+		switch kind {
+		case "domain":
+			list.domains[normalizeHost(value)] = true
+		case "prefix":
+			u, err := url.Parse(value)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("line %d: prefix must be an absolute url, got %q", n, value)
+			}
+			prefixes = append(prefixes, canonicalURL(u))
+		case "hash":
+			value = strings.ToLower(value)
+			if _, err := hex.DecodeString(value); err != nil || len(value) < hashPrefixMinLength || len(value) > sha256.Size*2 {
+				return nil, fmt.Errorf("line %d: hash must be %d to %d hex characters, got %q", n, hashPrefixMinLength, sha256.Size*2, value)
+			}
+			hashes = append(hashes, value)
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q, want domain, prefix or hash", n, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	list.prefixes, list.hashes = newPrefixIndex(prefixes), newPrefixIndex(hashes)
+	return list, nil
+}
+
+func (c *BlocklistChecker) CheckURL(rawURL string) (string, bool) {
+	list := c.list.Load()
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	host := normalizeHost(u.Hostname())
+	for d := host; d != ""; {
+		if list.domains[d] {
+			return "domain " + d + " is blocklisted", true
+		}
+		_, d, _ = strings.Cut(d, ".")
+	}
+	if prefix, ok := list.prefixes.match(canonicalURL(u)); ok {
+		return "url prefix " + prefix + " is blocklisted", true
+	}
+	if len(list.hashes) > 0 {
+		for _, expr := range urlExpressions(host, u) {
+			sum := sha256.Sum256([]byte(expr))
+			if prefix, ok := list.hashes.match(hex.EncodeToString(sum[:])); ok {
+				return "url matches blocklisted hash " + prefix, true
+			}
+		}
+	}
+	return "", false
+}
+
+// canonicalURL приводит схему и хост к нижнему регистру, чтобы префиксы сравнивались без учета регистра домена
+func canonicalURL(u *url.URL) string {
+	canonical := strings.ToLower(u.Scheme) + "://" + normalizeHost(u.Hostname())
+	if port := u.Port(); port != "" {
+		canonical += ":" + port
+	}
+	canonical += u.EscapedPath()
+	if u.RawQuery != "" {
+		canonical += "?" + u.RawQuery
+	}
+	return canonical
+}
+
+// urlExpressions - комбинации суффиксов хоста и префиксов пути, чьи хеши ищутся в списке, как в Safe Browsing:
+// для a.b.c/1/2.html?x=1 это a.b.c/1/2.html?x=1, a.b.c/1/2.html, a.b.c/, a.b.c/1/, b.c/1/2.html?x=1 и так далее
+func urlExpressions(host string, u *url.URL) []string {
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		start := max(1, len(labels)-maxHostExpressions)
+		for i := start; i < len(labels)-1 && len(hosts) < maxHostExpressions; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var paths []string
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+	dir := "/"
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if len(paths) >= maxPathExpressions || dir == path {
+			break
+		}
+		paths = append(paths, dir)
+		if segment == "" {
+			break
+		}
+		dir += segment + "/"
+	}
+
+	exprs := make([]string, 0, len(hosts)*len(paths))
+	for _, h := range hosts {
+		for _, p := range paths {
+			exprs = append(exprs, h+p)
+		}
+	}
+	return exprs
+}
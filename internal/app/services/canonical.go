@@ -0,0 +1,103 @@
+package services
+
+import (
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// defaultPorts - порты, которые можно не писать в url
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// trackingParams - метки рекламных систем, которые не меняют содержимое страницы
+var trackingParams = map[string]bool{
+	"fbclid":    true,
+	"gclid":     true,
+	"dclid":     true,
+	"msclkid":   true,
+	"yclid":     true,
+	"igshid":    true,
+	"mc_cid":    true,
+	"mc_eid":    true,
+	"_openstat": true,
+}
+
+// CanonicalURL приводит url к одному виду, чтобы одинаковые адреса получали один хеш и ловились
+// уникальностью в хранилище: схема и хост в нижнем регистре, IDN в punycode, без порта по умолчанию,
+// параметры отсортированы. Метки utm_* и фрагмент убираются, только если это включено в конфиге.
+// Результат сохраняется вместо исходного url, поэтому приведение включается явно.
+// Неразобранный url возвращается как есть, его отсеет ValidateURL
+func (s *URLShortenerService) CanonicalURL(rawURL string) string {
+	if !s.cfg.CanonicalizeURLs {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = canonicalHost(u.Scheme, u.Hostname(), u.Port())
+	u.RawQuery = canonicalQuery(u.RawQuery, s.cfg.StripTrackingParams)
+	u.ForceQuery = false
+	if s.cfg.StripFragment {
+		u.Fragment, u.RawFragment = "", ""
+	}
+	return u.String()
+}
+
+func canonicalHost(scheme, host, port string) string {
+	host = strings.ToLower(host)
+	// хосты, которые idna не принимает (например, с "_"), оставляем как есть
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// canonicalQuery сортирует параметры по имени, не перекодируя их:
+// порядок повторяющихся параметров и запись значений сохраняются
+func canonicalQuery(rawQuery string, stripTracking bool) string {
+	type param struct{ key, raw string }
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		rawKey, _, _ := strings.Cut(raw, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if stripTracking && isTrackingParam(key) {
+			continue
+		}
+		params = append(params, param{key: key, raw: raw})
+	}
+	slices.SortStableFunc(params, func(a, b param) int {
+		return strings.Compare(a.key, b.key)
+	})
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+)
+
+// URLChecker решает, не ведет ли url на вредоносный ресурс. Вызывается на каждом сохранении
+// и, если включено CheckOnRedirect, на каждом переходе, поэтому должен отвечать быстро.
+// Недоступность источника данных - не повод блокировать: реализация сама решает, как ее пережить
+type URLChecker interface {
+	// CheckURL возвращает причину и true, если url надо заблокировать
+	CheckURL(rawURL string) (reason string, flagged bool)
+}
+
+// SetURLChecker подключает проверку адресов, nil ее отключает. Вызывается до запуска серверов
+func (s *URLShortenerService) SetURLChecker(c URLChecker) {
+	s.checker = c
+}
+
+// checkURL - проверка при сохранении, ошибка оборачивает ErrURLNotAllowed, как и у URLPolicy
+func (s *URLShortenerService) checkURL(rawURL string) error {
+	if s.checker == nil {
+		return nil
+	}
+	if reason, flagged := s.checker.CheckURL(rawURL); flagged {
+		return policyError(ErrURLMalicious, "url %q is blocked: %s", rawURL, reason)
+	}
+	return nil
+}
+
+// CheckRedirect проверяет адрес перехода по уже сохраненной ссылке: попавшая в блок-лист после
+// сохранения ссылка отключается, вместо редиректа посетитель видит предупреждение
+func (s *URLShortenerService) CheckRedirect(location string) (reason string, blocked bool) {
+	if s.checker == nil || !s.cfg.CheckOnRedirect {
+		return "", false
+	}
+	return s.checker.CheckURL(location)
+}
+
+// CheckLink - CheckRedirect для API раскрытия ссылок: отключенная ссылка не отдает адрес и там
+func (s *URLShortenerService) CheckLink(rec repository.URLRecord) error {
+	if reason, blocked := s.CheckRedirect(rec.OriginalURL); blocked {
+		return fmt.Errorf("short url %q is disabled: %s: %w", rec.ShortURL, reason, ErrURLBlocked)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clicksBuffer        = 1024
+	clicksBatchSize     = 100
+	clicksFlushInterval = time.Second
+	clicksSaveTimeout   = 5 * time.Second
+	directReferrer      = "direct"
+)
+
+// Visit - данные перехода, которые хендлер достает из запроса
+type Visit struct {
+	Referrer  string
+	UserAgent string
+	ClientIP  string
+	// Variant - вариант сплита, на который ушел посетитель
+	Variant string
+}
+
+// clickRecorder копит переходы в буферизированном канале и пишет их пачками,
+// чтобы запись статистики не тормозила редирект
+type clickRecorder struct {
+	store  ClickStore
+	events chan repository.ClickEvent
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newClickRecorder(store ClickStore) *clickRecorder {
+	r := &clickRecorder{
+		store:  store,
+		events: make(chan repository.ClickEvent, clicksBuffer),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// Record не блокируется: при переполненном буфере или после Close событие теряется
+func (r *clickRecorder) Record(ev repository.ClickEvent) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.events <- ev:
+	default:
+		logger.Sugaarz.Warnw("clicks buffer is full, dropping click", "short", ev.ShortURL)
+	}
+}
+
+func (r *clickRecorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (r *clickRecorder) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(clicksFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]repository.ClickEvent, 0, clicksBatchSize)
+	for {
+		select {
+		case ev, ok := <-r.events:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) >= clicksBatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (r *clickRecorder) flush(batch []repository.ClickEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clicksSaveTimeout)
+	defer cancel()
+	if err := r.store.SaveClicks(ctx, batch); err != nil {
+		logger.Sugaarz.Errorw("failed to save clicks", "count", len(batch), "err", err)
+	}
+}
+
+func newClickEvent(urlHash string, visit Visit) repository.ClickEvent {
+	return repository.ClickEvent{
+		ShortURL:       urlHash,
+		ClickedAt:      time.Now().UTC(),
+		Referrer:       visit.Referrer,
+		ReferrerDomain: referrerDomain(visit.Referrer),
+		UserAgent:      visit.UserAgent,
+		Browser:        BrowserFamily(visit.UserAgent),
+		ClientIP:       visit.ClientIP,
+		Variant:        visit.Variant,
+	}
+}
+
+func referrerDomain(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return directReferrer
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
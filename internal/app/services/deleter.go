@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"sync"
+	"time"
+)
+
+const (
+	deleteWorkersCount  = 4
+	deleteJobsBuffer    = 64
+	deleteBatchSize     = 100
+	deleteFlushInterval = time.Second
+	deleteTimeout       = 10 * time.Second
+)
+
+type deleteJob struct {
+	userID string
+	hashes []string
+}
+
+// urlDeleter удаляет ссылки в фоне: воркеры разбирают задачи на отдельные запросы,
+// fan-in сводит их в один канал, а flusher копит пачку и отправляет ее в хранилище
+type urlDeleter struct {
+	repo Repository
+	jobs chan deleteJob
+	wg   sync.WaitGroup
+	// mu не дает отправить задачу в уже закрытый jobs
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newURLDeleter(repo Repository) *urlDeleter {
+	d := &urlDeleter{
+		repo: repo,
+		jobs: make(chan deleteJob, deleteJobsBuffer),
+	}
+
+	outs := make([]<-chan repository.DeleteRequest, 0, deleteWorkersCount)
+	for i := 0; i < deleteWorkersCount; i++ {
+		outs = append(outs, d.worker())
+	}
+
+	d.wg.Add(1)
+	go d.flusher(fanIn(outs...))
+	return d
+}
+
+// Enqueue не ждет освобождения очереди: при переполненной очереди или после Close
+// возвращает ErrDeleteQueueFull, и клиент может повторить запрос позже
+func (d *urlDeleter) Enqueue(userID string, hashes []string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return fmt.Errorf("deleter is closed: %w", ErrDeleteQueueFull)
+	}
+	select {
+	case d.jobs <- deleteJob{userID: userID, hashes: hashes}:
+		return nil
+	default:
+		return ErrDeleteQueueFull
+	}
+}
+
+// Close дожидается, пока все поставленные задачи попадут в хранилище
+func (d *urlDeleter) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.jobs)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *urlDeleter) worker() <-chan repository.DeleteRequest {
+	out := make(chan repository.DeleteRequest)
+	go func() {
+		defer close(out)
+		for job := range d.jobs {
+			for _, hash := range job.hashes {
+				out <- repository.DeleteRequest{UserID: job.userID, URLHash: hash}
+			}
+		}
+	}()
+	return out
+}
+
+func fanIn(chs ...<-chan repository.DeleteRequest) <-chan repository.DeleteRequest {
+	out := make(chan repository.DeleteRequest)
+	var wg sync.WaitGroup
+	for _, ch := range chs {
+		wg.Add(1)
+		go func(ch <-chan repository.DeleteRequest) {
+			defer wg.Done()
+			for req := range ch {
+				out <- req
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (d *urlDeleter) flusher(in <-chan repository.DeleteRequest) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(deleteFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]repository.DeleteRequest, 0, deleteBatchSize)
+	for {
+		select {
+		case req, ok := <-in:
+			if !ok {
+				d.flush(batch)
+				return
+			}
+			batch = append(batch, req)
+			if len(batch) >= deleteBatchSize {
+				d.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			d.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (d *urlDeleter) flush(batch []repository.DeleteRequest) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+	defer cancel()
+	if err := d.repo.DeleteURLs(ctx, batch); err != nil {
+		logger.Sugaarz.Errorw("failed to delete urls", "count", len(batch), "err", err)
+		return
+	}
+	logger.Sugaarz.Debugw("deleted urls batch", "count", len(batch))
+}
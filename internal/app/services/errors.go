@@ -3,5 +3,41 @@ package services
 import "errors"
 
 var (
-	ErrSave = errors.New("save error")
+	ErrSave                = errors.New("save error")
+	ErrAliasInvalid        = errors.New("invalid alias")
+	ErrAliasReserved       = errors.New("alias is reserved")
+	ErrAliasTaken          = errors.New("alias is already taken")
+	ErrURLAlreadyShortened = errors.New("url is already shortened under another id")
+	ErrURLExpired          = errors.New("url is expired")
+	ErrInvalidExpiry       = errors.New("invalid expiration")
+	ErrForbidden           = errors.New("access to url is forbidden")
+	ErrStatsUnavailable    = errors.New("click stats are not supported by storage")
+	ErrInvalidPassword     = errors.New("invalid password")
+	ErrPasswordRequired    = errors.New("url is protected by password")
+	ErrWrongPassword       = errors.New("wrong password")
+	ErrTooManyAttempts     = errors.New("too many failed password attempts")
+	ErrInvalidRedirectCode = errors.New("invalid redirect code")
+	ErrInvalidURL          = errors.New("invalid url")
+	ErrInvalidShortURL     = errors.New("invalid short url")
+	ErrInvalidTags         = errors.New("invalid tags")
+	ErrInvalidTitle        = errors.New("invalid title")
+	ErrInvalidListQuery    = errors.New("invalid list query")
+	ErrInvalidPassthrough  = errors.New("invalid passthrough mode")
+	ErrInvalidTargets      = errors.New("invalid target rules")
+	ErrInvalidVariants     = errors.New("invalid split variants")
+	ErrDeleteQueueFull     = errors.New("delete queue is full")
+	ErrURLBlocked          = errors.New("url is blocked")
+)
+
+// ошибки URLPolicy: каждая вместе с ErrURLNotAllowed называет причину отказа
+var (
+	ErrURLNotAllowed       = errors.New("url is not allowed")
+	ErrURLTooLong          = errors.New("url is too long")
+	ErrURLNotAbsolute      = errors.New("url is not absolute")
+	ErrURLSchemeNotAllowed = errors.New("url scheme is not allowed")
+	ErrURLPrivateAddress   = errors.New("url points to a private address")
+	ErrURLDomainDenied     = errors.New("url domain is denied")
+	ErrURLDomainNotAllowed = errors.New("url domain is not allowed")
+	ErrURLSelfRedirect     = errors.New("url points to the shortener itself")
+	ErrURLMalicious        = errors.New("url is blocklisted as malicious")
 )
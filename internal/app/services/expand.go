@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"net/url"
+	"strings"
+)
+
+// ParseShortID достает id из короткой ссылки нашего сервиса или возвращает сам id.
+// Схема не сравнивается: ссылку могли переписать с http на https
+func (s *URLShortenerService) ParseShortID(short string) (string, error) {
+	short = strings.TrimSpace(short)
+	id := short
+	if strings.Contains(short, "://") {
+		u, err := url.Parse(short)
+		if err != nil {
+			return "", fmt.Errorf("can not parse short url %q: %w", short, ErrInvalidShortURL)
+		}
+		base, err := url.Parse(s.cfg.BaseURL)
+		if err != nil {
+			return "", fmt.Errorf("can not parse base url: %w", err)
+		}
+		prefix := strings.TrimSuffix(base.Path, "/") + "/"
+		if !strings.EqualFold(u.Host, base.Host) || !strings.HasPrefix(u.Path, prefix) {
+			return "", fmt.Errorf("short url %q does not belong to %s: %w", short, s.cfg.BaseURL, ErrInvalidShortURL)
+		}
+		id = strings.TrimPrefix(u.Path, prefix)
+	}
+	id = strings.TrimSuffix(id, "/")
+	if id == "" || strings.ContainsAny(id, "/?#") {
+		return "", fmt.Errorf("got incorrect short url %q: %w", short, ErrInvalidShortURL)
+	}
+	return id, nil
+}
+
+// ExpandShortURL отдает запись по id или короткой ссылке. Как и GetLongURLFromDB, переход
+// не засчитывает. Куда ведет ссылка под паролем, без пароля не раскрываем,
+// а отключенная по блок-листу не раскрывается вовсе
+func (s *URLShortenerService) ExpandShortURL(ctx context.Context, short string) (repository.URLRecord, error) {
+	id, err := s.ParseShortID(short)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	rec, err := s.GetLink(ctx, id)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	if rec.IsProtected() {
+		return repository.URLRecord{}, ErrPasswordRequired
+	}
+	if err := s.CheckLink(rec); err != nil {
+		return repository.URLRecord{}, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResolveExpiry выбирает срок жизни ссылки: явная дата важнее ttl,
+// а анонимные ссылки без срока получают ttl по умолчанию из конфига
+func (s *URLShortenerService) ResolveExpiry(expiresAt *time.Time, ttlSeconds *int64, anonymous bool) (*time.Time, error) {
+	now := time.Now()
+	switch {
+	case expiresAt != nil:
+		if !expiresAt.After(now) {
+			return nil, fmt.Errorf("expires_at %s is in the past: %w", expiresAt.Format(time.RFC3339), ErrInvalidExpiry)
+		}
+		return expiresAt, nil
+	case ttlSeconds != nil:
+		if *ttlSeconds <= 0 {
+			return nil, fmt.Errorf("ttl_seconds must be positive: %w", ErrInvalidExpiry)
+		}
+		expires := now.Add(time.Duration(*ttlSeconds) * time.Second)
+		return &expires, nil
+	case anonymous && s.cfg.AnonymousTTL > 0:
+		expires := now.Add(s.cfg.AnonymousTTL)
+		return &expires, nil
+	default:
+		return nil, nil
+	}
+}
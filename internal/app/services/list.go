@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultListLimit - размер страницы, если клиент пришел с курсором, но без limit
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// ListParams - параметры списка ссылок пользователя в том виде, в каком их прислал клиент
+type ListParams struct {
+	UserID string
+	Tags   []string
+	Search string
+	// Sort - поле сортировки, "-" в начале - по убыванию. Пустое - по дате создания
+	Sort   string
+	Cursor string
+	// Limit 0 без курсора отдает весь список, как раньше
+	Limit int
+}
+
+// listCursor - содержимое курсора, sort в нем не дает продолжить выдачу в другом порядке
+type listCursor struct {
+	Sort     string `json:"s"`
+	Key      string `json:"k"`
+	ShortURL string `json:"id"`
+}
+
+func parseListSort(sort string) (repository.SortField, bool, error) {
+	field, desc := strings.CutPrefix(sort, "-")
+	switch repository.SortField(field) {
+	case "":
+		return repository.SortCreatedAt, desc, nil
+	case repository.SortCreatedAt, repository.SortOriginalURL, repository.SortTitle:
+		return repository.SortField(field), desc, nil
+	default:
+		return "", false, fmt.Errorf("unknown sort %q, expected created_at, original_url or title: %w", sort, ErrInvalidListQuery)
+	}
+}
+
+func encodeListCursor(sort string, c repository.Cursor) string {
+	b, _ := json.Marshal(listCursor{Sort: sort, Key: c.Key, ShortURL: c.ShortURL})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(raw, sort string, field repository.SortField) (*repository.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", ErrInvalidListQuery)
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ShortURL == "" {
+		return nil, fmt.Errorf("malformed cursor: %w", ErrInvalidListQuery)
+	}
+	if c.Sort != sort {
+		return nil, fmt.Errorf("cursor was issued for sort %q: %w", c.Sort, ErrInvalidListQuery)
+	}
+	if field == repository.SortCreatedAt {
+		if _, err := time.Parse(time.RFC3339Nano, c.Key); err != nil {
+			return nil, fmt.Errorf("malformed cursor: %w", ErrInvalidListQuery)
+		}
+	}
+	return &repository.Cursor{Key: c.Key, ShortURL: c.ShortURL}, nil
+}
+
+// FindUserURLs отдает страницу ссылок пользователя и курсор следующей, пустой - если страница последняя
+func (s *URLShortenerService) FindUserURLs(ctx context.Context, p ListParams) ([]repository.URLRecord, string, error) {
+	field, desc, err := parseListSort(p.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+	tags, err := NormalizeTags(p.Tags)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrInvalidListQuery, err)
+	}
+	if p.Limit < 0 || p.Limit > maxListLimit {
+		return nil, "", fmt.Errorf("limit must be from 1 to %d: %w", maxListLimit, ErrInvalidListQuery)
+	}
+	q := repository.URLQuery{
+		UserID: p.UserID,
+		Tags:   tags,
+		Search: strings.TrimSpace(p.Search),
+		Sort:   field,
+		Desc:   desc,
+		Limit:  p.Limit,
+	}
+	if p.Cursor != "" {
+		if q.After, err = decodeListCursor(p.Cursor, p.Sort, field); err != nil {
+			return nil, "", err
+		}
+		if q.Limit == 0 {
+			q.Limit = defaultListLimit
+		}
+	}
+	limit := q.Limit
+	if limit > 0 {
+		// на одну запись больше, чтобы понять, есть ли следующая страница
+		q.Limit++
+	}
+	urls, err := s.repo.FindUserURLs(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit == 0 || len(urls) <= limit {
+		return urls, "", nil
+	}
+	urls = urls[:limit]
+	return urls, encodeListCursor(p.Sort, q.CursorOf(urls[limit-1])), nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Режимы передачи пути и параметров запроса в адрес назначения, пустой - передача выключена
+const (
+	// PassthroughOverride - параметры запроса заменяют одноименные параметры ссылки
+	PassthroughOverride = "override"
+	// PassthroughKeep - одноименные параметры ссылки остаются как есть
+	PassthroughKeep = "keep"
+)
+
+func ValidatePassthrough(mode string) error {
+	switch mode {
+	case "", PassthroughOverride, PassthroughKeep:
+		return nil
+	default:
+		return fmt.Errorf("passthrough must be %q or %q, got %q: %w", PassthroughOverride, PassthroughKeep, mode, ErrInvalidPassthrough)
+	}
+}
+
+// Destination собирает адрес редиректа: у ссылок с передачей дописывает к нему остаток пути
+// после id (в экранированном виде) и сливает параметры запроса по правилу ссылки
+func Destination(originalURL, passthrough, extraPath string, query url.Values) (string, error) {
+	if passthrough == "" || (extraPath == "" && len(query) == 0) {
+		return originalURL, nil
+	}
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return "", fmt.Errorf("can not parse destination %q: %w", originalURL, err)
+	}
+	if extra := cleanExtraPath(extraPath); extra != "" {
+		u = u.JoinPath(extra)
+	}
+	if len(query) > 0 {
+		merged := u.Query()
+		for key, values := range query {
+			if _, exists := merged[key]; exists && passthrough == PassthroughKeep {
+				continue
+			}
+			merged[key] = values
+		}
+		u.RawQuery = merged.Encode()
+	}
+	return u.String(), nil
+}
+
+// cleanExtraPath разрешает "." и ".." внутри самого остатка пути, в том числе экранированные
+// как %2E: иначе JoinPath схлопнул бы их вместе с путем ссылки и переход ушел бы выше него
+func cleanExtraPath(extraPath string) string {
+	var segments []string
+	for _, segment := range strings.Split(extraPath, "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			unescaped = segment
+		}
+		switch unescaped {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"time"
+)
+
+const (
+	// bcrypt учитывает только первые 72 байта пароля, длиннее молча обрезал бы
+	passwordMaxLength = 72
+
+	passwordFailuresPerIP   = 10
+	passwordFailuresPerLink = 50
+	PasswordFailureWindow   = 15 * time.Minute
+	attemptsSweepThreshold  = 1024
+)
+
+// HashPassword готовит пароль к хранению рядом со ссылкой
+func (s *URLShortenerService) HashPassword(password string) (string, error) {
+	if len(password) > passwordMaxLength {
+		return "", fmt.Errorf("password must be at most %d bytes: %w", passwordMaxLength, ErrInvalidPassword)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// UnlockLink проверяет пароль защищенной ссылки. Неудачные попытки считаются отдельно по ссылке и по ip.
+// После лимита по ip с этого адреса не принимается даже верный пароль, после лимита по ссылке - ни
+// с какого до конца окна: иначе перебор с множества адресов ничем не ограничен. Владелец может
+// переждать окно или сменить пароль через PATCH
+func (s *URLShortenerService) UnlockLink(rec repository.URLRecord, password, clientIP string) error {
+	if !rec.IsProtected() {
+		return nil
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	linkKey, ipKey := "link:"+rec.ShortURL, "ip:"+clientIP
+	now := time.Now()
+	if s.attempts.Blocked(ipKey, passwordFailuresPerIP, now) || s.attempts.Blocked(linkKey, passwordFailuresPerLink, now) {
+		return ErrTooManyAttempts
+	}
+	if bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte(password)) == nil {
+		return nil
+	}
+	s.attempts.Fail(linkKey, now)
+	s.attempts.Fail(ipKey, now)
+	return ErrWrongPassword
+}
+
+type failureCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+// attemptLimiter считает неудачи в фиксированном окне по произвольным ключам
+type attemptLimiter struct {
+	window   time.Duration
+	failures map[string]failureCounter
+	mu       sync.Mutex
+}
+
+func newAttemptLimiter(window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		window:   window,
+		failures: make(map[string]failureCounter),
+	}
+}
+
+func (l *attemptLimiter) Blocked(key string, limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counter, exists := l.failures[key]
+	return exists && now.Before(counter.resetAt) && counter.count >= limit
+}
+
+func (l *attemptLimiter) Fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.failures) >= attemptsSweepThreshold {
+		l.sweep(now)
+	}
+	counter, exists := l.failures[key]
+	if !exists || !now.Before(counter.resetAt) {
+		counter = failureCounter{resetAt: now.Add(l.window)}
+	}
+	counter.count++
+	l.failures[key] = counter
+}
+
+// sweep убирает счетчики с истекшим окном, чтобы карта не росла бесконечно
+func (l *attemptLimiter) sweep(now time.Time) {
+	for key, counter := range l.failures {
+		if !now.Before(counter.resetAt) {
+			delete(l.failures, key)
+		}
+	}
+}
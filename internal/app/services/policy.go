@@ -0,0 +1,199 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxURLLength - длиннее url не пропускают многие браузеры и прокси
+const defaultMaxURLLength = 2048
+
+// URLPolicy - какие адреса можно сокращать. Загружается из json-файла, пустые поля берутся по умолчанию
+type URLPolicy struct {
+	AllowedSchemes []string `json:"allowed_schemes"`
+	// AllowDomains - если список не пуст, сокращать можно только эти домены.
+	// "example.com" совпадает только с самим доменом, "*.example.com" - с любым его поддоменом
+	AllowDomains []string `json:"allow_domains"`
+	// DenyDomains важнее AllowDomains, формат тот же
+	DenyDomains  []string `json:"deny_domains"`
+	MaxURLLength int      `json:"max_url_length"`
+	// AllowPrivateIPs пропускает адреса вида http://10.0.0.1, по умолчанию они запрещены
+	AllowPrivateIPs bool `json:"allow_private_ips"`
+}
+
+// DefaultURLPolicy - только http и https, без частных адресов и не длиннее defaultMaxURLLength
+func DefaultURLPolicy() *URLPolicy {
+	p := &URLPolicy{}
+	p.normalize()
+	return p
+}
+
+// LoadURLPolicy читает политику из json-файла, неизвестные поля считаются опечаткой
+func LoadURLPolicy(path string) (*URLPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not open url policy: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	p := &URLPolicy{}
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("can not parse url policy %s: %w", path, err)
+	}
+	for _, pattern := range slices.Concat(p.AllowDomains, p.DenyDomains) {
+		if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return nil, fmt.Errorf("url policy %s: wildcard is allowed only as a \"*.\" prefix, got %q", path, pattern)
+		}
+	}
+	p.normalize()
+	return p, nil
+}
+
+func (p *URLPolicy) normalize() {
+	if len(p.AllowedSchemes) == 0 {
+		p.AllowedSchemes = []string{"http", "https"}
+	}
+	if p.MaxURLLength <= 0 {
+		p.MaxURLLength = defaultMaxURLLength
+	}
+	for i := range p.AllowedSchemes {
+		p.AllowedSchemes[i] = strings.ToLower(strings.TrimSpace(p.AllowedSchemes[i]))
+	}
+	for _, domains := range [][]string{p.AllowDomains, p.DenyDomains} {
+		for i := range domains {
+			suffix, wildcard := strings.CutPrefix(strings.TrimSpace(domains[i]), "*.")
+			domains[i] = normalizeHost(suffix)
+			if wildcard {
+				domains[i] = "*." + domains[i]
+			}
+		}
+	}
+}
+
+// check проверяет разобранный url, ошибка всегда оборачивает ErrURLNotAllowed и ошибку с причиной
+func (p *URLPolicy) check(raw string, u *url.URL) error {
+	if len(raw) > p.MaxURLLength {
+		return policyError(ErrURLTooLong, "url is longer than %d characters", p.MaxURLLength)
+	}
+	if !u.IsAbs() {
+		return policyError(ErrURLNotAbsolute, "url %q must be absolute", raw)
+	}
+	if !slices.Contains(p.AllowedSchemes, strings.ToLower(u.Scheme)) {
+		return policyError(ErrURLSchemeNotAllowed, "scheme %q is not one of %v", u.Scheme, p.AllowedSchemes)
+	}
+	if u.Host == "" {
+		return policyError(ErrURLNotAbsolute, "url %q has no host", raw)
+	}
+	host := normalizeHost(u.Hostname())
+	if !p.AllowPrivateIPs {
+		if ip := parseHostIP(host); ip != nil && isPrivateIP(ip) {
+			return policyError(ErrURLPrivateAddress, "address %s is private or loopback", host)
+		}
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return policyError(ErrURLPrivateAddress, "host %s is loopback", host)
+		}
+	}
+	if pattern, found := matchDomain(host, p.DenyDomains); found {
+		return policyError(ErrURLDomainDenied, "domain %s is denied by %q", host, pattern)
+	}
+	if len(p.AllowDomains) > 0 {
+		if _, found := matchDomain(host, p.AllowDomains); !found {
+			return policyError(ErrURLDomainNotAllowed, "domain %s is not in the allow list", host)
+		}
+	}
+	return nil
+}
+
+func policyError(reason error, format string, args ...any) error {
+	return fmt.Errorf("%s: %w: %w", fmt.Sprintf(format, args...), ErrURLNotAllowed, reason)
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
+
+// parseHostIP разбирает ip в любой записи, которую понимают браузеры: кроме обычной это
+// 2130706433, 0x7f000001, 127.1 и 0177.0.0.1. Для доменного имени возвращает nil
+func parseHostIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	nums := make([]uint64, len(parts))
+	for i, part := range parts {
+		n, ok := parseIPv4Part(part)
+		if !ok {
+			return nil
+		}
+		nums[i] = n
+	}
+	// последняя часть занимает все оставшиеся байты адреса
+	last := nums[len(nums)-1]
+	if last >= 1<<(8*(5-len(nums))) {
+		return nil
+	}
+	addr := last
+	for i, n := range nums[:len(nums)-1] {
+		if n > 0xff {
+			return nil
+		}
+		addr |= n << (8 * (3 - i))
+	}
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+// parseIPv4Part разбирает часть адреса как браузер: 0x - шестнадцатеричная, ведущий 0 - восьмеричная
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case len(part) > 2 && (part[:2] == "0x" || part[:2] == "0X"):
+		part, base = part[2:], 16
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+	if strings.ContainsAny(part, "+-_") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(part, base, 32)
+	return n, err == nil
+}
+
+// normalizeHost убирает регистр и точку в конце и переводит IDN в punycode: EXAMPLE.com. и example.com -
+// один домен, как и пример.рф с xn--e1afmkfd.xn--p1ai. Так хост сравнивают и политика, и блок-лист
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	// хосты, которые idna не принимает (ip v6, имена с "_"), оставляем как есть
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		return ascii
+	}
+	return host
+}
+
+// matchDomain ищет шаблон, под который подходит host, и возвращает его для сообщения об ошибке
+func matchDomain(host string, patterns []string) (string, bool) {
+	for _, pattern := range patterns {
+		if suffix, wildcard := strings.CutPrefix(pattern, "*."); wildcard {
+			if strings.HasSuffix(host, "."+suffix) {
+				return pattern, true
+			}
+			continue
+		}
+		if host == pattern {
+			return pattern, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,14 @@
+package services
+
+import (
+	"context"
+	"github.com/stlesnik/url_shortener/internal/qrcode"
+)
+
+// QRCode кодирует полный короткий адрес живой ссылки в QR-код
+func (s *URLShortenerService) QRCode(ctx context.Context, URLHash string, level qrcode.Level) (*qrcode.Code, error) {
+	if _, err := s.GetLink(ctx, URLHash); err != nil {
+		return nil, err
+	}
+	return qrcode.Encode([]byte(s.PrepareShortURL(URLHash)), level)
+}
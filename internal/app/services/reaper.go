@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"github.com/stlesnik/url_shortener/internal/logger"
+	"time"
+)
+
+// Reaper периодически убирает из хранилища истекшие ссылки
+type Reaper struct {
+	repo     Repository
+	interval time.Duration
+}
+
+func NewReaper(repo Repository, interval time.Duration) *Reaper {
+	return &Reaper{repo: repo, interval: interval}
+}
+
+// Run блокируется до отмены контекста
+func (r *Reaper) Run(ctx context.Context) {
+	purger, ok := r.repo.(ExpiredPurger)
+	if !ok || r.interval <= 0 {
+		logger.Sugaarz.Infow("expired urls reaper is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := purger.PurgeExpired(ctx, now)
+			if err != nil {
+				logger.Sugaarz.Errorw("failed to purge expired urls", "err", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Sugaarz.Infow("purged expired urls", "count", purged)
+			}
+		}
+	}
+}
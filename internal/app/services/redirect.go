@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ValidateRedirectCode допускает только редиректы, при которых клиент переходит по Location
+func ValidateRedirectCode(code int) error {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	default:
+		return fmt.Errorf("redirect code must be one of 301, 302, 307, 308, got %d: %w", code, ErrInvalidRedirectCode)
+	}
+}
+
+// RedirectCode - код редиректа ссылки, а если он не задан - код по умолчанию из конфига
+func (s *URLShortenerService) RedirectCode(rec repository.URLRecord) int {
+	if rec.RedirectCode != 0 {
+		return rec.RedirectCode
+	}
+	if s.cfg.RedirectCode != 0 {
+		return s.cfg.RedirectCode
+	}
+	return http.StatusTemporaryRedirect
+}
+
+// RedirectCacheControl - заголовок Cache-Control для редиректа. Без него браузер навсегда
+// запоминает 301 и 308, и до сервиса не доходят переходы, правки ссылки и ее истечение.
+// Временные 302 и 307 не кешируются вовсе, постоянные - не дольше RedirectCacheTTL: пока ответ
+// в кеше, повторные переходы не попадают в статистику - это цена кеширования
+func (s *URLShortenerService) RedirectCacheControl(rec repository.URLRecord, now time.Time) string {
+	code := s.RedirectCode(rec)
+	if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+		return "no-store"
+	}
+	maxAge := s.cfg.RedirectCacheTTL
+	if rec.ExpiresAt != nil {
+		maxAge = min(maxAge, rec.ExpiresAt.Sub(now))
+	}
+	// редирект защищенной ссылки из кеша обошел бы пароль
+	if rec.IsProtected() || maxAge < time.Second {
+		return "no-store"
+	}
+	return "private, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+}
+
+// UpdateLink меняет параметры ссылки, короткий id при этом остается прежним. Доступно только владельцу
+func (s *URLShortenerService) UpdateLink(ctx context.Context, urlHash, userID string, patch repository.URLPatch) (repository.URLRecord, error) {
+	if patch.OriginalURL != nil {
+		if err := s.ValidateURL(*patch.OriginalURL); err != nil {
+			return repository.URLRecord{}, err
+		}
+		canonical := s.CanonicalURL(*patch.OriginalURL)
+		patch.OriginalURL = &canonical
+	}
+	if patch.RedirectCode != nil {
+		if err := ValidateRedirectCode(*patch.RedirectCode); err != nil {
+			return repository.URLRecord{}, err
+		}
+	}
+	if patch.Passthrough != nil {
+		if err := ValidatePassthrough(*patch.Passthrough); err != nil {
+			return repository.URLRecord{}, err
+		}
+	}
+	if patch.Targets != nil {
+		targets, err := s.ValidateTargets(*patch.Targets)
+		if err != nil {
+			return repository.URLRecord{}, err
+		}
+		patch.Targets = &targets
+	}
+	if patch.Variants != nil {
+		variants, err := s.ValidateVariants(*patch.Variants)
+		if err != nil {
+			return repository.URLRecord{}, err
+		}
+		patch.Variants = &variants
+	}
+	if patch.Title != nil {
+		title, err := NormalizeTitle(*patch.Title)
+		if err != nil {
+			return repository.URLRecord{}, err
+		}
+		patch.Title = &title
+	}
+	if patch.Tags != nil {
+		tags, err := NormalizeTags(*patch.Tags)
+		if err != nil {
+			return repository.URLRecord{}, err
+		}
+		patch.Tags = &tags
+	}
+	rec, err := s.repo.Get(ctx, urlHash)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	if rec.UserID == "" || rec.UserID != userID {
+		return repository.URLRecord{}, ErrForbidden
+	}
+	updated, err := s.repo.Update(ctx, urlHash, userID, patch)
+	if errors.Is(err, repository.ErrLongURLTaken) {
+		// хранилище может счесть занятым и прежний url, когда в запросе его нет
+		if patch.OriginalURL == nil {
+			return repository.URLRecord{}, fmt.Errorf("url of %q: %w", urlHash, ErrURLAlreadyShortened)
+		}
+		return repository.URLRecord{}, fmt.Errorf("url %q: %w", *patch.OriginalURL, ErrURLAlreadyShortened)
+	}
+	return updated, err
+}
@@ -3,15 +3,31 @@ package services
 import (
 	"context"
 	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"time"
 )
 
 type Repository interface {
 	Ping(ctx context.Context) error
-	Save(ctx context.Context, shortURL string, longURLStr string) (bool, error)
-	Get(ctx context.Context, shortURL string) (string, error)
+	Save(ctx context.Context, rec repository.URLRecord) (bool, error)
+	Get(ctx context.Context, shortURL string) (repository.URLRecord, error)
+	GetByOriginalURL(ctx context.Context, longURL string) (repository.URLRecord, error)
+	GetUserURLs(ctx context.Context, userID string) ([]repository.URLRecord, error)
+	FindUserURLs(ctx context.Context, q repository.URLQuery) ([]repository.URLRecord, error)
+	Update(ctx context.Context, shortURL, userID string, patch repository.URLPatch) (repository.URLRecord, error)
+	DeleteURLs(ctx context.Context, batch []repository.DeleteRequest) error
+	GetStats(ctx context.Context) (repository.ServiceStats, error)
 	Close() error
 }
 
 type BatchSaver interface {
-	SaveBatch(ctx context.Context, entries []repository.URLPair) error
+	SaveBatch(ctx context.Context, entries []repository.URLRecord) ([]repository.BatchResult, error)
+}
+
+type ExpiredPurger interface {
+	PurgeExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type ClickStore interface {
+	SaveClicks(ctx context.Context, events []repository.ClickEvent) error
+	GetClickStats(ctx context.Context, shortURL string) (repository.ClickStats, error)
 }
@@ -3,75 +3,216 @@ package services
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/stlesnik/url_shortener/internal/app/repository"
 	"github.com/stlesnik/url_shortener/internal/config"
-	"github.com/stlesnik/url_shortener/internal/logger"
 	"hash/fnv"
 	"net/url"
+	"strconv"
+	"time"
 )
 
+// maxHashAttempts ограничивает перебор хешей при коллизиях
+const maxHashAttempts = 8
+
 type URLShortenerService struct {
-	repo Repository
-	cfg  *config.Config
+	repo     Repository
+	cfg      *config.Config
+	deleter  *urlDeleter
+	clicks   *clickRecorder
+	attempts *attemptLimiter
+	policy   *URLPolicy
+	checker  URLChecker
 }
 
 func New(repo Repository, cfg *config.Config) *URLShortenerService {
-	return &URLShortenerService{repo, cfg}
+	s := &URLShortenerService{
+		repo:     repo,
+		cfg:      cfg,
+		deleter:  newURLDeleter(repo),
+		attempts: newAttemptLimiter(PasswordFailureWindow),
+		policy:   DefaultURLPolicy(),
+	}
+	if store, ok := repo.(ClickStore); ok {
+		s.clicks = newClickRecorder(store)
+	}
+	return s
+}
+
+// ShortenOptions - необязательные параметры создаваемой ссылки
+type ShortenOptions struct {
+	UserID         string
+	ExpiresAt      *time.Time
+	PasswordHash   string
+	RedirectCode   int
+	Title          string
+	Tags           []string
+	Passthrough    string
+	Targets        repository.TargetRules
+	Variants       repository.Variants
+	StickyVariants bool
+}
+
+func (o ShortenOptions) Record(urlHash, longURL string) repository.URLRecord {
+	return repository.URLRecord{
+		ShortURL:       urlHash,
+		OriginalURL:    longURL,
+		UserID:         o.UserID,
+		ExpiresAt:      o.ExpiresAt,
+		CreatedAt:      time.Now().UTC(),
+		PasswordHash:   o.PasswordHash,
+		RedirectCode:   o.RedirectCode,
+		Title:          o.Title,
+		Tags:           o.Tags,
+		Passthrough:    o.Passthrough,
+		Targets:        o.Targets,
+		Variants:       o.Variants,
+		StickyVariants: o.StickyVariants,
+	}
+}
+
+// LinkParams - параметры ссылки в том виде, в каком их прислал клиент, до проверки
+type LinkParams struct {
+	ExpiresAt    *time.Time
+	TTLSeconds   *int64
+	Password     string
+	RedirectCode int
+	Title        string
+	Tags         []string
+	Passthrough  string
+	Targets      []repository.TargetRule
+	Variants     []repository.Variant
+	// StickyVariants закрепляет посетителя за вариантом сплита
+	StickyVariants bool
 }
 
-func (s *URLShortenerService) CreateSavePrepareShortURL(ctx context.Context, longURL string) (string, bool, string) {
-	urlHash, err := s.CreateShortURLHash(longURL)
+// NewShortenOptions проверяет параметры клиента, одинаково для HTTP и gRPC.
+// anonymous - пользователь пришел без авторизации, его ссылкам достается срок жизни по умолчанию
+func (s *URLShortenerService) NewShortenOptions(userID string, anonymous bool, p LinkParams) (ShortenOptions, error) {
+	expires, err := s.ResolveExpiry(p.ExpiresAt, p.TTLSeconds, anonymous)
 	if err != nil {
-		return "", false, "Failed to create short URL, err: " + err.Error()
+		return ShortenOptions{}, err
+	}
+	opts := ShortenOptions{UserID: userID, ExpiresAt: expires}
+	if opts.Title, err = NormalizeTitle(p.Title); err != nil {
+		return ShortenOptions{}, err
+	}
+	if opts.Tags, err = NormalizeTags(p.Tags); err != nil {
+		return ShortenOptions{}, err
+	}
+	if p.RedirectCode != 0 {
+		if err := ValidateRedirectCode(p.RedirectCode); err != nil {
+			return ShortenOptions{}, err
+		}
+		opts.RedirectCode = p.RedirectCode
+	}
+	if err := ValidatePassthrough(p.Passthrough); err != nil {
+		return ShortenOptions{}, err
+	}
+	opts.Passthrough = p.Passthrough
+	if opts.Targets, err = s.ValidateTargets(p.Targets); err != nil {
+		return ShortenOptions{}, err
+	}
+	if opts.Variants, err = s.ValidateVariants(p.Variants); err != nil {
+		return ShortenOptions{}, err
+	}
+	opts.StickyVariants = p.StickyVariants
+	if p.Password != "" {
+		opts.PasswordHash, err = s.HashPassword(p.Password)
+		if err != nil {
+			return ShortenOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// CreateShortURL сохраняет url под хешем, для уже сокращенного url возвращает прежний id и isDouble
+func (s *URLShortenerService) CreateShortURL(ctx context.Context, longURL string, opts ShortenOptions) (string, bool, error) {
+	longURL = s.CanonicalURL(longURL)
+	// хеш может быть занят ссылкой, у которой с тех пор сменили адрес, тогда пробуем следующий
+	for attempt := 0; attempt < maxHashAttempts; attempt++ {
+		urlHash, err := shortURLHash(longURL, attempt)
+		if err != nil {
+			return "", false, fmt.Errorf("Failed to create short URL, err: %w", err)
+		}
+		isDouble, err := s.SaveShortURL(ctx, urlHash, longURL, opts)
+		if errors.Is(err, repository.ErrShortURLTaken) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("Failed to save short url, err: %w", err)
+		}
+		if isDouble {
+			if urlHash, err = s.existingShortURL(ctx, longURL, urlHash); err != nil {
+				return "", false, fmt.Errorf("Failed to save short url, err: %w", err)
+			}
+		}
+		return s.PrepareShortURL(urlHash), isDouble, nil
+	}
+	return "", false, fmt.Errorf("Failed to save short url, err: no free hash after %d attempts", maxHashAttempts)
+}
+
+// existingShortURL находит, под каким id уже сокращен url: это может быть алиас,
+// отредактированная ссылка или другая попытка хеша, а не только requested
+func (s *URLShortenerService) existingShortURL(ctx context.Context, longURL, requested string) (string, error) {
+	rec, err := s.repo.GetByOriginalURL(ctx, longURL)
+	if errors.Is(err, repository.ErrURLNotFound) {
+		return requested, nil
 	}
-	isDouble, err := s.SaveShortURL(ctx, urlHash, longURL)
 	if err != nil {
-		return "", false, "Failed to save short url, err: " + err.Error()
+		return "", err
 	}
-	shortURL := s.PrepareShortURL(urlHash)
-	return shortURL, isDouble, ""
+	return rec.ShortURL, nil
 }
 
 func (s *URLShortenerService) CreateShortURLHash(longURL string) (string, error) {
+	return shortURLHash(s.CanonicalURL(longURL), 0)
+}
+
+// shortURLHash детерминирован, поэтому повторное сокращение того же url проходит те же попытки
+func shortURLHash(longURL string, attempt int) (string, error) {
 	h := fnv.New64a()
 	_, err := h.Write([]byte(longURL))
+	if err == nil && attempt > 0 {
+		_, err = h.Write([]byte("#" + strconv.Itoa(attempt)))
+	}
 	if err != nil {
 		return "", err
 	}
 	return base64.URLEncoding.EncodeToString(h.Sum(nil)), nil
 }
 
-func (s *URLShortenerService) SaveShortURL(ctx context.Context, urlHash, longURL string) (isDouble bool, err error) {
-	isDouble, err = s.repo.Save(ctx, urlHash, longURL)
+func (s *URLShortenerService) SaveShortURL(ctx context.Context, urlHash, longURL string, opts ShortenOptions) (isDouble bool, err error) {
+	isDouble, err = s.repo.Save(ctx, opts.Record(urlHash, longURL))
+	if err == nil && isDouble && opts.PasswordHash != "" {
+		// пароль нельзя навесить на уже выданную ссылку: ее адрес мог уйти без пароля
+		return false, fmt.Errorf("url %q: %w", longURL, ErrURLAlreadyShortened)
+	}
 	return
 }
 
-func (s *URLShortenerService) SaveBatchShortURL(ctx context.Context, urlPairList []repository.URLPair) error {
-	if bSaver, ok := s.repo.(BatchSaver); ok {
-		logger.Sugaarz.Debugw("saving batch urls with BatchSaver")
-		err := bSaver.SaveBatch(ctx, urlPairList)
-		if err != nil {
-			return err
-		}
-	} else {
-		logger.Sugaarz.Debugw("saving batch urls ordinary way")
-		for _, urlPair := range urlPairList {
-			_, err := s.repo.Save(ctx, urlPair.URLHash, urlPair.LongURL)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+// SetURLPolicy заменяет политику по умолчанию, вызывается до запуска серверов
+func (s *URLShortenerService) SetURLPolicy(p *URLPolicy) {
+	s.policy = p
 }
 
+// ValidateURL проверяет, что url разбирается, проходит URLPolicy и URLChecker, а ссылка не ведет на сам сервис
 func (s *URLShortenerService) ValidateURL(longURL string) error {
-	_, err := url.ParseRequestURI(longURL)
+	u, err := url.ParseRequestURI(longURL)
 	if err != nil {
-		return fmt.Errorf("got incorrect url to shorten: url=%v, err= %w", longURL, err)
+		return fmt.Errorf("got incorrect url to shorten: url=%v, err=%v: %w", longURL, err, ErrInvalidURL)
+	}
+	// короткая ссылка на сервис привела бы к циклу редиректов. Проверяем до политики:
+	// хост сервиса часто локальный, а причина отказа здесь точнее
+	if base, err := url.Parse(s.cfg.BaseURL); err == nil && base.Hostname() != "" &&
+		normalizeHost(u.Hostname()) == normalizeHost(base.Hostname()) {
+		return policyError(ErrURLSelfRedirect, "url %q points to %s", longURL, s.cfg.BaseURL)
+	}
+	if err := s.policy.check(longURL, u); err != nil {
+		return err
 	}
-	return nil
+	return s.checkURL(longURL)
 }
 
 func (s *URLShortenerService) PrepareShortURL(urlHash string) string {
@@ -79,10 +220,71 @@ func (s *URLShortenerService) PrepareShortURL(urlHash string) string {
 }
 
 func (s *URLShortenerService) GetLongURLFromDB(ctx context.Context, URLHash string) (string, error) {
-	longURL, err := s.repo.Get(ctx, URLHash)
-	return longURL, err
+	rec, err := s.GetLink(ctx, URLHash)
+	if err != nil {
+		return "", err
+	}
+	return rec.OriginalURL, nil
+}
+
+// GetLink отдает запись только живой ссылки: удаленной или истекшей - ошибку
+func (s *URLShortenerService) GetLink(ctx context.Context, URLHash string) (repository.URLRecord, error) {
+	rec, err := s.repo.Get(ctx, URLHash)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	// ссылка могла истечь, а фоновый сборщик еще не успел до нее добраться
+	if rec.IsExpired(time.Now()) {
+		return repository.URLRecord{}, ErrURLExpired
+	}
+	return rec, nil
+}
+
+func (s *URLShortenerService) GetUserURLs(ctx context.Context, userID string) ([]repository.URLRecord, error) {
+	return s.repo.GetUserURLs(ctx, userID)
+}
+
+// DeleteUserURLs ставит удаление в очередь и сразу возвращает управление
+func (s *URLShortenerService) DeleteUserURLs(userID string, hashes []string) error {
+	return s.deleter.Enqueue(userID, hashes)
+}
+
+// RecordClick ставит переход в очередь на запись и не ждет хранилище
+func (s *URLShortenerService) RecordClick(urlHash string, visit Visit) {
+	if s.clicks == nil {
+		return
+	}
+	s.clicks.Record(newClickEvent(urlHash, visit))
+}
+
+// GetLinkStats отдает статистику переходов только владельцу ссылки
+func (s *URLShortenerService) GetLinkStats(ctx context.Context, urlHash, userID string) (repository.ClickStats, error) {
+	store, ok := s.repo.(ClickStore)
+	if !ok {
+		return repository.ClickStats{}, ErrStatsUnavailable
+	}
+	rec, err := s.repo.Get(ctx, urlHash)
+	if err != nil {
+		return repository.ClickStats{}, err
+	}
+	if rec.UserID == "" || rec.UserID != userID {
+		return repository.ClickStats{}, ErrForbidden
+	}
+	return store.GetClickStats(ctx, urlHash)
+}
+
+func (s *URLShortenerService) GetServiceStats(ctx context.Context) (repository.ServiceStats, error) {
+	return s.repo.GetStats(ctx)
 }
 
 func (s *URLShortenerService) PingDB(ctx context.Context) error {
 	return s.repo.Ping(ctx)
 }
+
+// Close дожидается завершения фоновых задач
+func (s *URLShortenerService) Close() {
+	s.deleter.Close()
+	if s.clicks != nil {
+		s.clicks.Close()
+	}
+}
@@ -2,43 +2,90 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"github.com/stlesnik/url_shortener/internal/app/repository"
 	"github.com/stlesnik/url_shortener/internal/config"
 	"github.com/stlesnik/url_shortener/internal/logger"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 type MockRepository struct {
-	storage map[string]string
+	storage map[string]repository.URLRecord
 	fail    bool
 }
 
 func (m *MockRepository) Ping(_ context.Context) error { return nil }
 
-func (m *MockRepository) Save(_ context.Context, shortURL, longURL string) (bool, error) {
+func (m *MockRepository) Save(_ context.Context, rec repository.URLRecord) (bool, error) {
 	if m.fail {
 		return false, ErrSave
 	}
-	m.storage[shortURL] = longURL
+	m.storage[rec.ShortURL] = rec
 	return false, nil
 }
 
-func (m *MockRepository) Get(_ context.Context, shortURL string) (string, error) {
+func (m *MockRepository) Get(_ context.Context, shortURL string) (repository.URLRecord, error) {
 	val, exists := m.storage[shortURL]
 	if !exists {
-		return "", repository.ErrURLNotFound
+		return repository.URLRecord{}, repository.ErrURLNotFound
 	}
 	return val, nil
 }
 
+func (m *MockRepository) GetByOriginalURL(_ context.Context, longURL string) (repository.URLRecord, error) {
+	for _, val := range m.storage {
+		if val.OriginalURL == longURL {
+			return val, nil
+		}
+	}
+	return repository.URLRecord{}, repository.ErrURLNotFound
+}
+
+func (m *MockRepository) GetUserURLs(_ context.Context, _ string) ([]repository.URLRecord, error) {
+	return nil, nil
+}
+
+func (m *MockRepository) FindUserURLs(_ context.Context, _ repository.URLQuery) ([]repository.URLRecord, error) {
+	return nil, nil
+}
+
+func (m *MockRepository) Update(_ context.Context, shortURL, _ string, _ repository.URLPatch) (repository.URLRecord, error) {
+	if m.fail {
+		return repository.URLRecord{}, repository.ErrLongURLTaken
+	}
+	val, exists := m.storage[shortURL]
+	if !exists {
+		return repository.URLRecord{}, repository.ErrURLNotFound
+	}
+	return val, nil
+}
+
+func (m *MockRepository) DeleteURLs(_ context.Context, _ []repository.DeleteRequest) error {
+	return nil
+}
+
+func (m *MockRepository) GetStats(_ context.Context) (repository.ServiceStats, error) {
+	return repository.ServiceStats{URLs: len(m.storage)}, nil
+}
+
 func (m *MockRepository) Close() error {
 	return nil
 }
 
-func TestServices_CreateSavePrepareShortURL(t *testing.T) {
+func TestServices_CreateShortURL(t *testing.T) {
 	tests := []struct {
 		name        string
 		longURL     string
@@ -63,18 +110,18 @@ func TestServices_CreateSavePrepareShortURL(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := &MockRepository{
-				storage: make(map[string]string),
+				storage: make(map[string]repository.URLRecord),
 				fail:    tt.repoFailure,
 			}
 			service := New(repo, cfg)
 
-			shortURL, _, errMsg := service.CreateSavePrepareShortURL(context.Background(), tt.longURL)
+			shortURL, _, err := service.CreateShortURL(context.Background(), tt.longURL, ShortenOptions{UserID: "user"})
 
 			if tt.wantError {
-				assert.NotEmpty(t, errMsg)
+				assert.Error(t, err)
 				assert.Empty(t, shortURL)
 			} else {
-				assert.Empty(t, errMsg)
+				assert.NoError(t, err)
 				assert.Contains(t, shortURL, cfg.BaseURL)
 				assert.NotEmpty(t, shortURL)
 			}
@@ -82,6 +129,85 @@ func TestServices_CreateSavePrepareShortURL(t *testing.T) {
 	}
 }
 
+func TestServices_ValidateURL(t *testing.T) {
+	service := New(&MockRepository{storage: make(map[string]repository.URLRecord)}, &config.Config{BaseURL: "http://localhost:8080"})
+	assert.NoError(t, service.ValidateURL("https://google.com"))
+	assert.ErrorIs(t, service.ValidateURL("not a url"), ErrInvalidURL)
+
+	tests := []struct {
+		name    string
+		policy  *URLPolicy
+		url     string
+		wantErr error
+	}{
+		{"Relative path", nil, "/foo", ErrURLNotAbsolute},
+		{"Javascript", nil, "javascript:alert(1)", ErrURLSchemeNotAllowed},
+		{"Ftp by default", nil, "ftp://files.ru/a", ErrURLSchemeNotAllowed},
+		{"Too long", nil, "https://a.ru/" + strings.Repeat("a", defaultMaxURLLength), ErrURLTooLong},
+		{"Loopback", nil, "http://127.0.0.1/admin", ErrURLPrivateAddress},
+		{"Private v6", nil, "http://[fd00::1]/", ErrURLPrivateAddress},
+		{"Public ip", nil, "http://8.8.8.8/", nil},
+		{"Own host", nil, "https://LOCALHOST:9000/abc", ErrURLSelfRedirect},
+		{"Decimal loopback", nil, "http://2130706433/", ErrURLPrivateAddress},
+		{"Short loopback", nil, "http://127.1/", ErrURLPrivateAddress},
+		{"Hex loopback", nil, "http://0x7f000001/", ErrURLPrivateAddress},
+		{"Octal private", nil, "http://012.0.0.1/", ErrURLPrivateAddress},
+		{"Mapped v6 loopback", nil, "http://[::ffff:127.0.0.1]/", ErrURLPrivateAddress},
+		{"Localhost subdomain", nil, "http://api.Localhost./", ErrURLPrivateAddress},
+		{"Numeric-looking domain", nil, "http://1.2.3.4.5/", nil},
+		{"Public decimal ip", nil, "http://134744072/", nil},
+		{"Private allowed", &URLPolicy{AllowPrivateIPs: true}, "http://10.0.0.1/", nil},
+		{"Ftp allowed", &URLPolicy{AllowedSchemes: []string{"FTP"}}, "ftp://files.ru/a", nil},
+		{"Denied subdomain", &URLPolicy{DenyDomains: []string{"*.evil.com"}}, "https://www.Evil.com./", ErrURLDomainDenied},
+		{"Wildcard skips apex", &URLPolicy{DenyDomains: []string{"*.evil.com"}}, "https://evil.com/", nil},
+		{"Allowed domain", &URLPolicy{AllowDomains: []string{"ya.ru", "*.ya.ru"}}, "https://maps.ya.ru/", nil},
+		{"Not in allow list", &URLPolicy{AllowDomains: []string{"ya.ru"}}, "https://google.com/", ErrURLDomainNotAllowed},
+		{"Deny wins over allow", &URLPolicy{AllowDomains: []string{"*.ya.ru"}, DenyDomains: []string{"ads.ya.ru"}}, "https://ads.ya.ru/", ErrURLDomainDenied},
+		{"Unicode host, punycode rule", &URLPolicy{DenyDomains: []string{"xn--e1afmkfd.xn--p1ai"}}, "https://Пример.рф/", ErrURLDomainDenied},
+		{"Punycode host, unicode rule", &URLPolicy{DenyDomains: []string{"*.пример.рф"}}, "https://www.xn--e1afmkfd.xn--p1ai/", ErrURLDomainDenied},
+		{"Unicode host in allow list", &URLPolicy{AllowDomains: []string{"xn--e1afmkfd.xn--p1ai"}}, "https://пример.рф/", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultURLPolicy()
+			if tt.policy != nil {
+				tt.policy.normalize()
+				policy = tt.policy
+			}
+			service.SetURLPolicy(policy)
+			err := service.ValidateURL(tt.url)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.ErrorIs(t, err, ErrURLNotAllowed)
+		})
+	}
+}
+
+func TestServices_LoadURLPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	policy, err := LoadURLPolicy(write("ok.json", `{"deny_domains":["*.Evil.com."],"max_url_length":100}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http", "https"}, policy.AllowedSchemes)
+	assert.Equal(t, []string{"*.evil.com"}, policy.DenyDomains)
+	assert.Equal(t, 100, policy.MaxURLLength)
+
+	_, err = LoadURLPolicy(write("typo.json", `{"deny_domain":["evil.com"]}`))
+	assert.Error(t, err)
+	_, err = LoadURLPolicy(write("wildcard.json", `{"allow_domains":["ya.*"]}`))
+	assert.Error(t, err)
+	_, err = LoadURLPolicy(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
 func TestServices_CreateShortURLHash(t *testing.T) {
 	service := New(nil, &config.Config{})
 
@@ -98,6 +224,65 @@ func TestServices_CreateShortURLHash(t *testing.T) {
 		assert.NotEmpty(t, hash1)
 		assert.NotEmpty(t, hash2)
 	})
+
+	t.Run("Canonical hash", func(t *testing.T) {
+		service := New(nil, &config.Config{CanonicalizeURLs: true})
+		hash1, err1 := service.CreateShortURLHash("HTTP://Example.com:80/a?b=1&a=2")
+		hash2, err2 := service.CreateShortURLHash("http://example.com/a?a=2&b=1")
+
+		require.NoError(t, err1)
+		require.NoError(t, err2)
+		assert.Equal(t, hash1, hash2)
+	})
+}
+
+func TestServices_CanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		in   string
+		want string
+	}{
+		{"Disabled", config.Config{}, "HTTP://Example.com:80/a?b=1&a=2", "HTTP://Example.com:80/a?b=1&a=2"},
+		{"Scheme, host and default port", config.Config{CanonicalizeURLs: true}, "HTTP://Example.COM:80/Path", "http://example.com/Path"},
+		{"Https default port", config.Config{CanonicalizeURLs: true}, "https://example.com:443/", "https://example.com/"},
+		{"Other port kept", config.Config{CanonicalizeURLs: true}, "https://example.com:8443/", "https://example.com:8443/"},
+		{"IDN to punycode", config.Config{CanonicalizeURLs: true}, "https://Пример.рф/путь", "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{"IPv6 host", config.Config{CanonicalizeURLs: true}, "http://[::1]:80/a", "http://[::1]/a"},
+		{"Query sorted, repeated keys keep order", config.Config{CanonicalizeURLs: true}, "http://example.com/?b=2&a=1&b=1&c", "http://example.com/?a=1&b=2&b=1&c"},
+		{"Query encoding kept", config.Config{CanonicalizeURLs: true}, "http://example.com/?q=a%20b&p=x+y", "http://example.com/?p=x+y&q=a%20b"},
+		{"Tracking params kept by default", config.Config{CanonicalizeURLs: true}, "http://example.com/?utm_source=x&id=1", "http://example.com/?id=1&utm_source=x"},
+		{"Tracking params stripped", config.Config{CanonicalizeURLs: true, StripTrackingParams: true}, "http://example.com/?UTM_Source=x&id=1&fbclid=y&gclid=z", "http://example.com/?id=1"},
+		{"Only tracking params", config.Config{CanonicalizeURLs: true, StripTrackingParams: true}, "http://example.com/a?utm_medium=x", "http://example.com/a"},
+		{"Fragment kept by default", config.Config{CanonicalizeURLs: true}, "http://example.com/a#top", "http://example.com/a#top"},
+		{"Fragment stripped", config.Config{CanonicalizeURLs: true, StripFragment: true}, "http://example.com/a?x=1#top", "http://example.com/a?x=1"},
+		{"Not absolute", config.Config{CanonicalizeURLs: true}, "/relative?b=1&a=2", "/relative?b=1&a=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := New(nil, &tt.cfg)
+			assert.Equal(t, tt.want, service.CanonicalURL(tt.in))
+		})
+	}
+}
+
+func TestServices_CreateShortURL_Canonical(t *testing.T) {
+	repo := &MockRepository{storage: make(map[string]repository.URLRecord)}
+	service := New(repo, &config.Config{BaseURL: "http://localhost:8080", CanonicalizeURLs: true})
+
+	first, _, err := service.CreateShortURL(context.Background(), "HTTP://Example.com:80/a?b=1&a=2", ShortenOptions{})
+	require.NoError(t, err)
+	second, _, err := service.CreateShortURL(context.Background(), "http://example.com/a?a=2&b=1", ShortenOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	entry := service.NewBatchEntry("HTTP://EXAMPLE.com/a?b=1&a=2", "", false, LinkParams{})
+	require.NoError(t, entry.Err)
+	assert.Equal(t, "http://example.com/a?a=2&b=1", entry.LongURL)
+
+	for _, rec := range repo.storage {
+		assert.Equal(t, "http://example.com/a?a=2&b=1", rec.OriginalURL)
+	}
 }
 
 func TestServices_SaveShortURL(t *testing.T) {
@@ -117,19 +302,19 @@ func TestServices_SaveShortURL(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := &MockRepository{
-				storage: make(map[string]string),
+				storage: make(map[string]repository.URLRecord),
 				fail:    tt.repoFailure,
 			}
 			service := New(repo, cfg)
 
-			_, err := service.SaveShortURL(context.Background(), hash, longURL)
+			_, err := service.SaveShortURL(context.Background(), hash, longURL, ShortenOptions{UserID: "user"})
 
 			if tt.wantError {
 				assert.Error(t, err)
 				assert.Empty(t, repo.storage)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, longURL, repo.storage[hash])
+				assert.Equal(t, longURL, repo.storage[hash].OriginalURL)
 			}
 		})
 	}
@@ -149,31 +334,200 @@ func TestServices_SaveBatchShortURL(t *testing.T) {
 	cfg := &config.Config{}
 	err := logger.InitLogger(cfg.Environment)
 	require.NoError(t, err)
-	urlPairList := []repository.URLPair{
-		{URLHash: "https://google.com", LongURL: "abc123"},
-		{URLHash: "https://google1.com", LongURL: "abc1234"},
-	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			repo := &MockRepository{
-				storage: make(map[string]string),
+				storage: make(map[string]repository.URLRecord),
 				fail:    tt.repoFailure,
 			}
 
 			service := New(repo, cfg)
 
-			err := service.SaveBatchShortURL(context.Background(), urlPairList)
+			entries := []BatchEntry{
+				{LongURL: "https://google.com", Opts: ShortenOptions{UserID: "user"}},
+				{LongURL: "https://google1.com", Opts: ShortenOptions{UserID: "user"}},
+			}
+			err := service.SaveBatchShortURL(context.Background(), entries)
 
 			if tt.wantError {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
+				for _, entry := range entries {
+					assert.Equal(t, BatchCreated, entry.Status)
+					assert.NotEmpty(t, entry.ShortURL)
+				}
 			}
 		})
 	}
 }
 
+func TestPurgeExpired_Tombstones(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	fileRepo, err := repository.NewFileStorage(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = fileRepo.Close()
+	}()
+
+	repos := map[string]interface {
+		Repository
+		ExpiredPurger
+	}{
+		"memory": repository.NewInMemoryRepository(),
+		"file":   fileRepo,
+	}
+	past := time.Now().Add(-time.Hour)
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			_, err := repo.Save(ctx, repository.URLRecord{ShortURL: "old", OriginalURL: "https://old.example.com", ExpiresAt: &past})
+			require.NoError(t, err)
+
+			purged, err := repo.PurgeExpired(ctx, time.Now())
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), purged)
+			purged, err = repo.PurgeExpired(ctx, time.Now())
+			require.NoError(t, err)
+			assert.Zero(t, purged)
+
+			// ссылка остается в хранилище: отдается 410, а id не переиспользуется
+			_, err = repo.Get(ctx, "old")
+			assert.ErrorIs(t, err, repository.ErrURLDeleted)
+			_, err = repo.Save(ctx, repository.URLRecord{ShortURL: "old", OriginalURL: "https://new.example.com"})
+			assert.ErrorIs(t, err, repository.ErrShortURLTaken)
+		})
+	}
+
+	// из схлопнутого файла истекшая ссылка пропадает, удаленная пользователем остается
+	_, err = fileRepo.Save(ctx, repository.URLRecord{ShortURL: "gone", OriginalURL: "https://gone.example.com", UserID: "user"})
+	require.NoError(t, err)
+	require.NoError(t, fileRepo.DeleteURLs(ctx, []repository.DeleteRequest{{UserID: "user", URLHash: "gone"}}))
+	_, err = fileRepo.Save(ctx, repository.URLRecord{ShortURL: "older", OriginalURL: "https://older.example.com", ExpiresAt: &past})
+	require.NoError(t, err)
+	_, err = fileRepo.PurgeExpired(ctx, time.Now())
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "old.example.com")
+	assert.NotContains(t, string(content), "older.example.com")
+	assert.Contains(t, string(content), "gone.example.com")
+
+	reopened, err := repository.NewFileStorage(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = reopened.Close()
+	}()
+	_, err = reopened.Get(ctx, "old")
+	assert.ErrorIs(t, err, repository.ErrURLNotFound)
+	_, err = reopened.Get(ctx, "gone")
+	assert.ErrorIs(t, err, repository.ErrURLDeleted)
+}
+
+func TestServices_CreateShortURL_DeadLinks(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	ctx := context.Background()
+	fileRepo, err := repository.NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+	defer func() {
+		_ = fileRepo.Close()
+	}()
+
+	for name, repo := range map[string]Repository{"memory": repository.NewInMemoryRepository(), "file": fileRepo} {
+		t.Run(name, func(t *testing.T) {
+			service := New(repo, cfg)
+			defer service.Close()
+
+			// удаленная ссылка отдает 410, а ее url сокращается заново под новым id
+			deleted, _, err := service.CreateShortURL(ctx, "https://deleted.example.com", ShortenOptions{UserID: "user"})
+			require.NoError(t, err)
+			require.NoError(t, repo.DeleteURLs(ctx, []repository.DeleteRequest{{UserID: "user", URLHash: strings.TrimPrefix(deleted, cfg.BaseURL+"/")}}))
+			again, isDouble, err := service.CreateShortURL(ctx, "https://deleted.example.com", ShortenOptions{})
+			require.NoError(t, err)
+			assert.False(t, isDouble)
+			assert.NotEqual(t, deleted, again)
+			_, err = service.GetLink(ctx, strings.TrimPrefix(deleted, cfg.BaseURL+"/"))
+			assert.ErrorIs(t, err, repository.ErrURLDeleted)
+
+			past := time.Now().Add(-time.Minute)
+			expired, _, err := service.CreateShortURL(ctx, "https://expired.example.com", ShortenOptions{ExpiresAt: &past})
+			require.NoError(t, err)
+			entries := []BatchEntry{{LongURL: "https://expired.example.com"}}
+			require.NoError(t, service.SaveBatchShortURL(ctx, entries))
+			assert.Equal(t, BatchCreated, entries[0].Status)
+			assert.NotEqual(t, expired, entries[0].ShortURL)
+
+			// живая ссылка по-прежнему находится как существующая
+			_, isDouble, err = service.CreateShortURL(ctx, "https://expired.example.com", ShortenOptions{})
+			require.NoError(t, err)
+			assert.True(t, isDouble)
+		})
+	}
+}
+
+func TestServices_UpdateLink_ReshortenedURL(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	ctx := context.Background()
+	fileRepo, err := repository.NewFileStorage(filepath.Join(t.TempDir(), "urls.json"))
+	require.NoError(t, err)
+	defer func() {
+		_ = fileRepo.Close()
+	}()
+
+	for name, repo := range map[string]Repository{"memory": repository.NewInMemoryRepository(), "file": fileRepo} {
+		t.Run(name, func(t *testing.T) {
+			service := New(repo, cfg)
+			defer service.Close()
+
+			// url истекшей ссылки сокращен заново, но ее название по-прежнему можно поменять
+			past := time.Now().Add(-time.Minute)
+			expired, _, err := service.CreateShortURL(ctx, "https://reused.example.com", ShortenOptions{UserID: "owner", ExpiresAt: &past})
+			require.NoError(t, err)
+			_, isDouble, err := service.CreateShortURL(ctx, "https://reused.example.com", ShortenOptions{})
+			require.NoError(t, err)
+			require.False(t, isDouble)
+
+			title := "Old campaign"
+			rec, err := service.UpdateLink(ctx, strings.TrimPrefix(expired, cfg.BaseURL+"/"), "owner", repository.URLPatch{Title: &title})
+			require.NoError(t, err)
+			assert.Equal(t, title, rec.Title)
+			assert.Equal(t, "https://reused.example.com", rec.OriginalURL)
+		})
+	}
+}
+
+func TestServices_SaveBatchShortURL_Statuses(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	repo := repository.NewInMemoryRepository()
+	service := New(repo, cfg)
+	defer service.Close()
+	ctx := context.Background()
+
+	// url уже сокращен под алиасом - в ответе должен быть именно алиас
+	_, err := repo.Save(ctx, repository.URLRecord{ShortURL: "my-alias", OriginalURL: "https://ya.ru"})
+	require.NoError(t, err)
+
+	entries := []BatchEntry{
+		{LongURL: "https://google.com"},
+		{LongURL: "https://ya.ru"},
+		{LongURL: "https://google.com"},
+		{LongURL: "bad"},
+	}
+	entries[3].Invalidate(errors.New("bad url"))
+	require.NoError(t, service.SaveBatchShortURL(ctx, entries))
+
+	assert.Equal(t, BatchCreated, entries[0].Status)
+	assert.Equal(t, BatchExisting, entries[1].Status)
+	assert.Equal(t, "http://localhost:8080/my-alias", entries[1].ShortURL)
+	assert.Equal(t, BatchExisting, entries[2].Status)
+	assert.Equal(t, entries[0].ShortURL, entries[2].ShortURL)
+	assert.Equal(t, BatchInvalid, entries[3].Status)
+	assert.Empty(t, entries[3].ShortURL)
+}
+
 func TestServices_PrepareShortURL(t *testing.T) {
 	cfg := &config.Config{BaseURL: "http://localhost:8080"}
 	service := New(nil, cfg)
@@ -184,24 +538,27 @@ func TestServices_PrepareShortURL(t *testing.T) {
 }
 
 func TestServices_GetLongURLFromDB(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
 	tests := []struct {
 		name        string
 		hash        string
 		prepopulate bool
+		expiresAt   *time.Time
 		wantURL     string
 		wantError   bool
 	}{
-		{"Existing URL", "abc123", true, "https://google.com", false},
-		{"Non-existent URL", "badhash", false, "", true},
+		{"Existing URL", "abc123", true, nil, "https://google.com", false},
+		{"Non-existent URL", "badhash", false, nil, "", true},
+		{"Expired URL", "abc123", true, &past, "", true},
 	}
 
 	cfg := &config.Config{}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := &MockRepository{storage: make(map[string]string)}
+			repo := &MockRepository{storage: make(map[string]repository.URLRecord)}
 			if tt.prepopulate {
-				repo.storage[tt.hash] = tt.wantURL
+				repo.storage[tt.hash] = repository.URLRecord{ShortURL: tt.hash, OriginalURL: "https://google.com", ExpiresAt: tt.expiresAt}
 			}
 			service := New(repo, cfg)
 
@@ -217,3 +574,530 @@ func TestServices_GetLongURLFromDB(t *testing.T) {
 		})
 	}
 }
+
+func TestServices_RedirectCacheControl(t *testing.T) {
+	now := time.Now()
+	soon, past := now.Add(90*time.Second), now.Add(-time.Second)
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		rec  repository.URLRecord
+		want string
+	}{
+		{"Permanent", 5 * time.Minute, repository.URLRecord{RedirectCode: http.StatusMovedPermanently}, "private, max-age=300"},
+		{"Permanent 308", 5 * time.Minute, repository.URLRecord{RedirectCode: http.StatusPermanentRedirect}, "private, max-age=300"},
+		{"Temporary by default", 5 * time.Minute, repository.URLRecord{}, "no-store"},
+		{"Temporary 302", 5 * time.Minute, repository.URLRecord{RedirectCode: http.StatusFound}, "no-store"},
+		{"Caching disabled", 0, repository.URLRecord{RedirectCode: http.StatusMovedPermanently}, "no-store"},
+		{"Limited by expiry", 5 * time.Minute, repository.URLRecord{RedirectCode: http.StatusMovedPermanently, ExpiresAt: &soon}, "private, max-age=90"},
+		{"Already expired", 5 * time.Minute, repository.URLRecord{RedirectCode: http.StatusMovedPermanently, ExpiresAt: &past}, "no-store"},
+		{"Protected", 5 * time.Minute, repository.URLRecord{RedirectCode: http.StatusMovedPermanently, PasswordHash: "hash"}, "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := New(nil, &config.Config{RedirectCacheTTL: tt.ttl})
+			assert.Equal(t, tt.want, service.RedirectCacheControl(tt.rec, now))
+		})
+	}
+}
+
+func TestServices_ParseShortID(t *testing.T) {
+	service := New(nil, &config.Config{BaseURL: "http://localhost:8080/s"})
+
+	tests := []struct {
+		name      string
+		short     string
+		wantID    string
+		wantError bool
+	}{
+		{"Bare id", "abc123", "abc123", false},
+		{"Full short url", "http://localhost:8080/s/abc123", "abc123", false},
+		{"Other scheme and case", " https://LOCALHOST:8080/s/abc123/ ", "abc123", false},
+		{"Query is ignored", "http://localhost:8080/s/abc123?utm=1", "abc123", false},
+		{"Other host", "http://example.com/s/abc123", "", true},
+		{"Other path", "http://localhost:8080/abc123", "", true},
+		{"Nested path", "http://localhost:8080/s/abc123/qr", "", true},
+		{"Empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := service.ParseShortID(tt.short)
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrInvalidShortURL)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantID, id)
+		})
+	}
+}
+
+func TestServices_NormalizeTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      []string
+		want      []string
+		wantError bool
+	}{
+		{"Empty", nil, nil, false},
+		{"Only blanks", []string{" ", ""}, nil, false},
+		{"Lowercased, sorted and unique", []string{" Work", "code", "work "}, []string{"code", "work"}, false},
+		{"Comma", []string{"a,b"}, nil, true},
+		{"Too long", []string{strings.Repeat("x", tagMaxLength+1)}, nil, true},
+		{"Too many", strings.Split("a b c d e f g h i j k l m n o p q r s t u", " "), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTags(tt.tags)
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrInvalidTags)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServices_Destination(t *testing.T) {
+	const original = "https://example.com/docs?utm_source=link&a=1"
+	tests := []struct {
+		name        string
+		passthrough string
+		extraPath   string
+		query       url.Values
+		want        string
+	}{
+		{"Passthrough off", "", "extra", url.Values{"utm_source": {"x"}}, original},
+		{"Nothing to pass", PassthroughOverride, "", nil, original},
+		{"Override", PassthroughOverride, "", url.Values{"utm_source": {"x"}, "b": {"2"}}, "https://example.com/docs?a=1&b=2&utm_source=x"},
+		{"Keep", PassthroughKeep, "", url.Values{"utm_source": {"x"}, "b": {"2"}}, "https://example.com/docs?a=1&b=2&utm_source=link"},
+		{"Path appended", PassthroughKeep, "v1/page", nil, "https://example.com/docs/v1/page?utm_source=link&a=1"},
+		{"Dot segments stay inside", PassthroughKeep, "v1/../../../admin", nil, "https://example.com/docs/admin?utm_source=link&a=1"},
+		{"Escaped dot segments stay inside", PassthroughKeep, "%2E%2E/%2e%2e/admin", nil, "https://example.com/docs/admin?utm_source=link&a=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Destination(original, tt.passthrough, tt.extraPath, tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.ErrorIs(t, ValidatePassthrough("merge"), ErrInvalidPassthrough)
+}
+
+func TestServices_ClientOSAndDevice(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		os        string
+		device    string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", OSiOS, DeviceMobile},
+		{"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", OSiOS, DeviceTablet},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", OSAndroid, DeviceMobile},
+		{"Mozilla/5.0 (Linux; Android 14; SM-X710) AppleWebKit/537.36 Chrome/120.0 Safari/537.36", OSAndroid, DeviceTablet},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15", OSMacOS, DeviceDesktop},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", OSLinux, DeviceDesktop},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "", DeviceBot},
+		{"", "", DeviceDesktop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			assert.Equal(t, tt.os, ClientOS(tt.userAgent))
+			assert.Equal(t, tt.device, ClientDevice(tt.userAgent))
+		})
+	}
+}
+
+func TestServices_ValidateTargets(t *testing.T) {
+	service := New(nil, &config.Config{})
+	tests := []struct {
+		name      string
+		rules     repository.TargetRules
+		want      repository.TargetRules
+		wantError bool
+	}{
+		{"Empty", repository.TargetRules{}, nil, false},
+		{"Normalized", repository.TargetRules{{OS: " iOS ", URL: "https://a.ru"}}, repository.TargetRules{{OS: "ios", URL: "https://a.ru"}}, false},
+		{"No condition", repository.TargetRules{{URL: "https://a.ru"}}, nil, true},
+		{"Unknown device", repository.TargetRules{{Device: "watch", URL: "https://a.ru"}}, nil, true},
+		{"Bad url", repository.TargetRules{{OS: "ios", URL: "not a url"}}, nil, true},
+		{"Too many", make(repository.TargetRules, maxTargetRules+1), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.ValidateTargets(tt.rules)
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrInvalidTargets)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServices_ValidateVariants(t *testing.T) {
+	service := New(nil, &config.Config{})
+	tests := []struct {
+		name      string
+		variants  repository.Variants
+		want      repository.Variants
+		wantError bool
+	}{
+		{"Empty", repository.Variants{}, nil, false},
+		{"Default names", repository.Variants{{URL: "https://a.ru", Weight: 70}, {Name: " Promo ", URL: "https://b.ru", Weight: 30}},
+			repository.Variants{{Name: "a", URL: "https://a.ru", Weight: 70}, {Name: "promo", URL: "https://b.ru", Weight: 30}}, false},
+		{"Single variant", repository.Variants{{URL: "https://a.ru", Weight: 1}}, nil, true},
+		{"Duplicate name", repository.Variants{{Name: "b", URL: "https://a.ru", Weight: 1}, {URL: "https://b.ru", Weight: 1}}, nil, true},
+		{"Bad name", repository.Variants{{Name: "a b", URL: "https://a.ru", Weight: 1}, {URL: "https://b.ru", Weight: 1}}, nil, true},
+		{"Negative weight", repository.Variants{{URL: "https://a.ru", Weight: -1}, {URL: "https://b.ru", Weight: 1}}, nil, true},
+		{"All paused", repository.Variants{{URL: "https://a.ru"}, {URL: "https://b.ru"}}, nil, true},
+		{"Bad url", repository.Variants{{URL: "not a url", Weight: 1}, {URL: "https://b.ru", Weight: 1}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.ValidateVariants(tt.variants)
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrInvalidVariants)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServices_ChooseDestination(t *testing.T) {
+	const iPhone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148"
+	split := repository.URLRecord{ShortURL: "landing", OriginalURL: "https://shop.ru", Variants: repository.Variants{
+		{Name: "a", URL: "https://shop.ru/a", Weight: 70},
+		{Name: "b", URL: "https://shop.ru/b", Weight: 30},
+		{Name: "old", URL: "https://shop.ru/old", Weight: 0},
+	}}
+	sticky := split
+	sticky.StickyVariants = true
+	targeted := sticky
+	targeted.Targets = repository.TargetRules{{OS: OSiOS, URL: "https://apps.apple.com/app/shop"}}
+
+	target, variant := ChooseDestination(repository.URLRecord{OriginalURL: "https://shop.ru"}, iPhone, "", "user")
+	assert.Equal(t, "https://shop.ru", target)
+	assert.Empty(t, variant)
+
+	target, variant = ChooseDestination(targeted, iPhone, "b", "user")
+	assert.Equal(t, "https://apps.apple.com/app/shop", target, "device rule wins over the split")
+	assert.Empty(t, variant)
+
+	target, variant = ChooseDestination(sticky, "", "b", "user")
+	assert.Equal(t, "https://shop.ru/b", target, "assigned variant is kept")
+	assert.Equal(t, "b", variant)
+
+	counts := map[string]int{}
+	for i := range 1000 {
+		visitor := strconv.Itoa(i)
+		target, variant := ChooseDestination(sticky, "", "old", visitor)
+		again, _ := ChooseDestination(sticky, "", "", visitor)
+		require.Equal(t, target, again, "sticky choice depends only on the visitor")
+		counts[variant]++
+
+		_, variant = ChooseDestination(split, "", "b", visitor)
+		assert.NotEqual(t, "old", variant, "paused variant is never chosen")
+	}
+	assert.Zero(t, counts["old"])
+	assert.InDelta(t, 700, counts["a"], 100)
+	assert.InDelta(t, 300, counts["b"], 100)
+}
+
+func TestBlocklistChecker(t *testing.T) {
+	require.NoError(t, logger.InitLogger(""))
+	hashOf := func(expr string) string {
+		sum := sha256.Sum256([]byte(expr))
+		return hex.EncodeToString(sum[:])[:hashPrefixMinLength]
+	}
+	sum := sha256.Sum256([]byte("full.org/"))
+	fullHash := hex.EncodeToString(sum[:])
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`# test list
+domain:evil.com
+domain:xn--b1aec7b.xn--p1ai
+prefix:https://пример.рф/phish/
+prefix:https://Docs.example.com/phish/
+prefix:https://docs.example.com/phish/deep/
+prefix:https://docs.example.com/other
+hash:`+hashOf("bad.org/login/")+`
+hash:`+hashOf("bad.org/login/")+`00
+hash:`+fullHash+`
+`), 0o600))
+	checker, err := NewBlocklistChecker(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url     string
+		flagged bool
+	}{
+		{"https://evil.com/", true},
+		{"http://a.b.EVIL.com./x", true},
+		{"https://notevil.com/", false},
+		{"https://docs.example.com/phish/form?id=1", true},
+		{"https://docs.example.com/public/", false},
+		{"https://docs.example.com/phish/deep/x", true},
+		{"https://docs.example.com/otherwise", true},
+		{"https://docs.example.com/p", false},
+		{"https://www.bad.org/login/step2.html?x=1", true},
+		{"https://bad.org/logout/", false},
+		{"https://www.full.org/", true},
+		{"https://вред.рф/", true},
+		{"https://xn--e1afmkfd.xn--p1ai/phish/form", true},
+		{"https://full.org.ru/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			reason, flagged := checker.CheckURL(tt.url)
+			assert.Equal(t, tt.flagged, flagged)
+			assert.Equal(t, tt.flagged, reason != "")
+		})
+	}
+
+	// при изменении файла список подменяется, при ошибке разбора остается прежний
+	require.NoError(t, os.WriteFile(path, []byte("domain:other.com\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	reloaded, err := checker.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	_, flagged := checker.CheckURL("https://evil.com/")
+	assert.False(t, flagged)
+
+	require.NoError(t, os.WriteFile(path, []byte("host:evil.com\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	_, err = checker.reload()
+	assert.Error(t, err)
+	_, flagged = checker.CheckURL("https://other.com/")
+	assert.True(t, flagged)
+
+	service := New(nil, &config.Config{CheckOnRedirect: true})
+	service.SetURLChecker(checker)
+	err = service.ValidateURL("https://other.com/")
+	assert.ErrorIs(t, err, ErrURLMalicious)
+	assert.ErrorIs(t, err, ErrURLNotAllowed)
+	_, blocked := service.CheckRedirect("https://other.com/")
+	assert.True(t, blocked)
+}
+
+func TestServices_ValidateAlias(t *testing.T) {
+	cfg := &config.Config{ReservedAliases: []string{"ping", "api"}}
+	service := New(nil, cfg)
+
+	tests := []struct {
+		name    string
+		alias   string
+		wantErr error
+	}{
+		{"Valid alias", "my_link-1", nil},
+		{"Too short", "ab", ErrAliasInvalid},
+		{"Too long", strings.Repeat("a", aliasMaxLength+1), ErrAliasInvalid},
+		{"Bad charset", "hello/world", ErrAliasInvalid},
+		{"Reserved word", "Ping", ErrAliasReserved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.ValidateAlias(tt.alias)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServices_ResolveExpiry(t *testing.T) {
+	service := New(nil, &config.Config{AnonymousTTL: time.Hour})
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	ttl := int64(60)
+	badTTL := int64(0)
+
+	tests := []struct {
+		name       string
+		expiresAt  *time.Time
+		ttlSeconds *int64
+		anonymous  bool
+		wantNil    bool
+		wantErr    bool
+	}{
+		{name: "No expiration", wantNil: true},
+		{name: "Explicit date", expiresAt: &future},
+		{name: "Date in the past", expiresAt: &past, wantErr: true},
+		{name: "TTL", ttlSeconds: &ttl},
+		{name: "Non positive TTL", ttlSeconds: &badTTL, wantErr: true},
+		{name: "Anonymous default", anonymous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := service.ResolveExpiry(tt.expiresAt, tt.ttlSeconds, tt.anonymous)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidExpiry)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantNil {
+				assert.Nil(t, got)
+			} else {
+				require.NotNil(t, got)
+				assert.True(t, got.After(time.Now()))
+			}
+		})
+	}
+}
+
+func TestBrowserFamily(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", "Chrome"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0", "Edge"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "Safari"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Bot"},
+		{"curl/8.4.0", "curl"},
+		{"", "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, BrowserFamily(tt.userAgent))
+		})
+	}
+}
+
+func TestServices_GetLinkStats(t *testing.T) {
+	cfg := &config.Config{}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	repo := repository.NewInMemoryRepository()
+	_, err := repo.Save(context.Background(), repository.URLRecord{ShortURL: "abc123", OriginalURL: "https://google.com", UserID: "user"})
+	require.NoError(t, err)
+	service := New(repo, cfg)
+
+	service.RecordClick("abc123", Visit{Referrer: "https://www.ya.ru/search", UserAgent: "curl/8.4.0", Variant: "b"})
+	service.RecordClick("abc123", Visit{})
+	// дожидаемся сброса буфера переходов
+	service.Close()
+
+	stats, err := service.GetLinkStats(context.Background(), "abc123", "user")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Total)
+	assert.Equal(t, map[string]int64{"ya.ru": 1, "direct": 1}, stats.ByReferrer)
+	assert.Equal(t, map[string]int64{"curl": 1, "Unknown": 1}, stats.ByBrowser)
+	assert.Equal(t, map[string]int64{time.Now().UTC().Format("2006-01-02"): 2}, stats.ByDay)
+	assert.Equal(t, map[string]int64{"b": 1}, stats.ByVariant)
+
+	_, err = service.GetLinkStats(context.Background(), "abc123", "stranger")
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestServices_UnlockLink(t *testing.T) {
+	cfg := &config.Config{}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	service := New(repository.NewInMemoryRepository(), cfg)
+	defer service.Close()
+
+	hash, err := service.HashPassword("secret")
+	require.NoError(t, err)
+	rec := repository.URLRecord{ShortURL: "abc123", OriginalURL: "https://google.com", PasswordHash: hash}
+
+	assert.NoError(t, service.UnlockLink(repository.URLRecord{ShortURL: "open"}, "", "10.0.0.1"))
+	assert.ErrorIs(t, service.UnlockLink(rec, "", "10.0.0.1"), ErrPasswordRequired)
+	assert.NoError(t, service.UnlockLink(rec, "secret", "10.0.0.1"))
+
+	for i := 0; i < passwordFailuresPerIP; i++ {
+		assert.ErrorIs(t, service.UnlockLink(rec, "wrong", "10.0.0.1"), ErrWrongPassword)
+	}
+	// после лимита с этого ip не принимается даже верный пароль, а с другого - принимается
+	assert.ErrorIs(t, service.UnlockLink(rec, "secret", "10.0.0.1"), ErrTooManyAttempts)
+	assert.NoError(t, service.UnlockLink(rec, "secret", "10.0.0.2"))
+
+	// перебор с разных адресов упирается в лимит ссылки, после него до конца окна не проходит и верный пароль.
+	// Минимальная стоимость bcrypt, чтобы полсотни проверок не тянулись секундами
+	cheap, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	rec.PasswordHash = string(cheap)
+	for i := 0; i < passwordFailuresPerLink; i++ {
+		_ = service.UnlockLink(rec, "wrong", "10.1.0."+strconv.Itoa(i))
+	}
+	assert.ErrorIs(t, service.UnlockLink(rec, "wrong", "10.2.0.1"), ErrTooManyAttempts)
+	assert.ErrorIs(t, service.UnlockLink(rec, "secret", "10.2.0.2"), ErrTooManyAttempts)
+	other := repository.URLRecord{ShortURL: "other", OriginalURL: "https://ya.ru", PasswordHash: string(cheap)}
+	assert.NoError(t, service.UnlockLink(other, "secret", "10.2.0.2"), "other links are not locked")
+
+	_, err = service.HashPassword(strings.Repeat("a", passwordMaxLength+1))
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+}
+
+func TestURLDeleter_Enqueue(t *testing.T) {
+	// без воркеров очередь не разбирается, и второй задаче уже нет места
+	d := &urlDeleter{jobs: make(chan deleteJob, 1)}
+	require.NoError(t, d.Enqueue("user", []string{"a"}))
+	assert.ErrorIs(t, d.Enqueue("user", []string{"b"}), ErrDeleteQueueFull)
+
+	service := New(&MockRepository{storage: make(map[string]repository.URLRecord)}, &config.Config{})
+	require.NoError(t, service.DeleteUserURLs("user", []string{"a"}))
+	service.Close()
+	assert.ErrorIs(t, service.DeleteUserURLs("user", []string{"b"}), ErrDeleteQueueFull)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	limiter := newAttemptLimiter(time.Minute)
+	now := time.Now()
+	limiter.Fail("key", now)
+	limiter.Fail("key", now)
+	assert.True(t, limiter.Blocked("key", 2, now))
+	assert.False(t, limiter.Blocked("key", 3, now))
+	assert.False(t, limiter.Blocked("other", 1, now))
+	// окно истекло - счетчик начинается заново
+	assert.False(t, limiter.Blocked("key", 2, now.Add(time.Minute)))
+	limiter.Fail("key", now.Add(time.Minute))
+	assert.False(t, limiter.Blocked("key", 2, now.Add(time.Minute)))
+}
+
+func TestServices_UpdateLink(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	require.NoError(t, logger.InitLogger(cfg.Environment))
+	service := New(repository.NewInMemoryRepository(), cfg)
+	defer service.Close()
+	ctx := context.Background()
+
+	shortURL, _, err := service.CreateShortURL(ctx, "https://google.com", ShortenOptions{UserID: "owner"})
+	require.NoError(t, err)
+	urlHash := strings.TrimPrefix(shortURL, cfg.BaseURL+"/")
+
+	newURL := "https://ya.ru"
+	_, err = service.UpdateLink(ctx, urlHash, "stranger", repository.URLPatch{OriginalURL: &newURL})
+	assert.ErrorIs(t, err, ErrForbidden)
+	rec, err := service.UpdateLink(ctx, urlHash, "owner", repository.URLPatch{OriginalURL: &newURL})
+	require.NoError(t, err)
+	assert.Equal(t, newURL, rec.OriginalURL)
+
+	// старый адрес занимает хеш отредактированной ссылки, поэтому получает другой id, и повторно тот же
+	again, isDouble, err := service.CreateShortURL(ctx, "https://google.com", ShortenOptions{})
+	require.NoError(t, err)
+	assert.False(t, isDouble)
+	assert.NotEqual(t, shortURL, again)
+	repeated, isDouble, err := service.CreateShortURL(ctx, "https://google.com", ShortenOptions{})
+	require.NoError(t, err)
+	assert.True(t, isDouble)
+	assert.Equal(t, again, repeated)
+}
+
+func TestServices_UpdateLink_TitleOnlyURLTaken(t *testing.T) {
+	repo := &MockRepository{storage: map[string]repository.URLRecord{
+		"expired": {ShortURL: "expired", OriginalURL: "https://reused.example.com", UserID: "owner"},
+	}}
+	service := New(repo, &config.Config{BaseURL: "http://localhost:8080"})
+	defer service.Close()
+
+	// истекшая ссылка, чей url сокращен заново: хранилище отвечает ErrLongURLTaken и на патч без url
+	repo.fail = true
+	title := "Old campaign"
+	_, err := service.UpdateLink(context.Background(), "expired", "owner", repository.URLPatch{Title: &title})
+	assert.ErrorIs(t, err, ErrURLAlreadyShortened)
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTags        = 20
+	tagMaxLength   = 64
+	titleMaxLength = 256
+)
+
+// NormalizeTags приводит теги к нижнему регистру, убирает пустые и повторы и сортирует.
+// Запятая запрещена: в CSV-выгрузке теги перечисляются через нее
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > tagMaxLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters: %w", tag, tagMaxLength, ErrInvalidTags)
+		}
+		if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+			return nil, fmt.Errorf("tag %q contains a comma or a control character: %w", tag, ErrInvalidTags)
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	normalized = slices.Compact(normalized)
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("link may have at most %d tags, got %d: %w", maxTags, len(normalized), ErrInvalidTags)
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// NormalizeTitle обрезает пробелы по краям заголовка и проверяет длину
+func NormalizeTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > titleMaxLength {
+		return "", fmt.Errorf("title is longer than %d characters: %w", titleMaxLength, ErrInvalidTitle)
+	}
+	if strings.ContainsFunc(title, unicode.IsControl) {
+		return "", fmt.Errorf("title contains a control character: %w", ErrInvalidTitle)
+	}
+	return title, nil
+}
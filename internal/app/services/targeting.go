@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"slices"
+	"strings"
+)
+
+// Значения условий правил: OS и класс устройства клиента
+const (
+	OSiOS     = "ios"
+	OSAndroid = "android"
+	OSWindows = "windows"
+	OSMacOS   = "macos"
+	OSLinux   = "linux"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// maxTargetRules - правила перебираются на каждом переходе, длинные списки ни к чему
+const maxTargetRules = 20
+
+var (
+	targetOSes    = []string{OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux}
+	targetDevices = []string{DeviceMobile, DeviceTablet, DeviceDesktop, DeviceBot}
+)
+
+// порядок важен: Android пишет о себе Linux, а iOS - Mac OS X
+var osRules = []uaRule{
+	{"iphone", OSiOS},
+	{"ipad", OSiOS},
+	{"ipod", OSiOS},
+	{"android", OSAndroid},
+	{"windows", OSWindows},
+	{"macintosh", OSMacOS},
+	{"mac os x", OSMacOS},
+	{"linux", OSLinux},
+	{"x11", OSLinux},
+}
+
+// ClientOS определяет OS клиента по User-Agent, пустая строка - не удалось
+func ClientOS(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, rule := range osRules {
+		if strings.Contains(ua, rule.marker) {
+			return rule.name
+		}
+	}
+	return ""
+}
+
+// ClientDevice определяет класс устройства по User-Agent, все неопознанное считается компьютером
+func ClientDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case BrowserFamily(userAgent) == "Bot":
+		return DeviceBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// ValidateTargets проверяет правила: у каждого есть хотя бы одно известное условие и корректный url
+func (s *URLShortenerService) ValidateTargets(rules repository.TargetRules) (repository.TargetRules, error) {
+	if len(rules) > maxTargetRules {
+		return nil, fmt.Errorf("link may have at most %d target rules, got %d: %w", maxTargetRules, len(rules), ErrInvalidTargets)
+	}
+	normalized := make(repository.TargetRules, 0, len(rules))
+	for i, rule := range rules {
+		rule.OS = strings.ToLower(strings.TrimSpace(rule.OS))
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		if rule.OS == "" && rule.Device == "" {
+			return nil, fmt.Errorf("target rule %d has no os or device: %w", i, ErrInvalidTargets)
+		}
+		if rule.OS != "" && !slices.Contains(targetOSes, rule.OS) {
+			return nil, fmt.Errorf("target rule %d: os must be one of %v, got %q: %w", i, targetOSes, rule.OS, ErrInvalidTargets)
+		}
+		if rule.Device != "" && !slices.Contains(targetDevices, rule.Device) {
+			return nil, fmt.Errorf("target rule %d: device must be one of %v, got %q: %w", i, targetDevices, rule.Device, ErrInvalidTargets)
+		}
+		if err := s.ValidateURL(rule.URL); err != nil {
+			return nil, fmt.Errorf("target rule %d: %w: %w", i, ErrInvalidTargets, err)
+		}
+		normalized = append(normalized, rule)
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// MatchTarget возвращает url первого правила ссылки, подходящего клиенту
+func MatchTarget(rec repository.URLRecord, userAgent string) (string, bool) {
+	if len(rec.Targets) == 0 {
+		return "", false
+	}
+	clientOS, device := ClientOS(userAgent), ClientDevice(userAgent)
+	for _, rule := range rec.Targets {
+		if (rule.OS == "" || rule.OS == clientOS) && (rule.Device == "" || rule.Device == device) {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
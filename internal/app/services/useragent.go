@@ -0,0 +1,47 @@
+package services
+
+import "strings"
+
+type uaRule struct {
+	marker string
+	name   string
+}
+
+// порядок важен: Edge и Opera тоже пишут о себе Chrome, а Chrome - Safari
+var browserRules = []uaRule{
+	{"edg", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"yabrowser", "Yandex Browser"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"firefox", "Firefox"},
+	{"fxios", "Firefox"},
+	{"crios", "Chrome"},
+	{"chrome", "Chrome"},
+	{"chromium", "Chrome"},
+	{"safari", "Safari"},
+	{"msie", "Internet Explorer"},
+	{"trident", "Internet Explorer"},
+	{"curl", "curl"},
+}
+
+var botMarkers = []string{"bot", "spider", "crawl", "slurp"}
+
+// BrowserFamily грубо определяет семейство браузера по User-Agent
+func BrowserFamily(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return "Unknown"
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return "Bot"
+		}
+	}
+	for _, rule := range browserRules {
+		if strings.Contains(ua, rule.marker) {
+			return rule.name
+		}
+	}
+	return "Other"
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"github.com/stlesnik/url_shortener/internal/app/repository"
+	"hash/fnv"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+)
+
+const (
+	minVariants          = 2
+	maxVariants          = 10
+	maxVariantWeight     = 1000
+	variantNameMaxLength = 32
+)
+
+// имя варианта уходит в куку посетителя, поэтому только безопасные для нее символы
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ValidateVariants проверяет сплит: от 2 до 10 вариантов с уникальными именами и корректными url.
+// Безымянные варианты получают имена a, b, c... по порядку, вес 0 ставит вариант на паузу
+func (s *URLShortenerService) ValidateVariants(variants repository.Variants) (repository.Variants, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < minVariants || len(variants) > maxVariants {
+		return nil, fmt.Errorf("split must have from %d to %d variants, got %d: %w", minVariants, maxVariants, len(variants), ErrInvalidVariants)
+	}
+	normalized := make(repository.Variants, 0, len(variants))
+	names := make(map[string]bool, len(variants))
+	total := 0
+	for i, v := range variants {
+		v.Name = strings.ToLower(strings.TrimSpace(v.Name))
+		if v.Name == "" {
+			v.Name = string(rune('a' + i))
+		}
+		if len(v.Name) > variantNameMaxLength || !variantNamePattern.MatchString(v.Name) {
+			return nil, fmt.Errorf("variant name %q may contain only latin letters, digits, '-' and '_' and be up to %d characters: %w", v.Name, variantNameMaxLength, ErrInvalidVariants)
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("variant name %q is used twice: %w", v.Name, ErrInvalidVariants)
+		}
+		names[v.Name] = true
+		if v.Weight < 0 || v.Weight > maxVariantWeight {
+			return nil, fmt.Errorf("variant %q: weight must be from 0 to %d, got %d: %w", v.Name, maxVariantWeight, v.Weight, ErrInvalidVariants)
+		}
+		if err := s.ValidateURL(v.URL); err != nil {
+			return nil, fmt.Errorf("variant %q: %w: %w", v.Name, ErrInvalidVariants, err)
+		}
+		total += v.Weight
+		normalized = append(normalized, v)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one variant must have a positive weight: %w", ErrInvalidVariants)
+	}
+	return normalized, nil
+}
+
+// ChooseDestination выбирает адрес перехода: правило по устройству важнее сплита, без них - основной url.
+// assigned - вариант из куки посетителя, visitorKey делает выбор для закрепляемых ссылок детерминированным.
+// variant пустой, если сплит не участвовал
+func ChooseDestination(rec repository.URLRecord, userAgent, assigned, visitorKey string) (target, variant string) {
+	if target, ok := MatchTarget(rec, userAgent); ok {
+		return target, ""
+	}
+	if len(rec.Variants) == 0 {
+		return rec.OriginalURL, ""
+	}
+	v := chooseVariant(rec, assigned, visitorKey)
+	return v.URL, v.Name
+}
+
+func chooseVariant(rec repository.URLRecord, assigned, visitorKey string) repository.Variant {
+	total := 0
+	for _, v := range rec.Variants {
+		if rec.StickyVariants && v.Name == assigned && v.Weight > 0 {
+			return v
+		}
+		total += v.Weight
+	}
+	var n int
+	if rec.StickyVariants {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(rec.ShortURL + "/" + visitorKey))
+		n = int(h.Sum64() % uint64(total))
+	} else {
+		n = rand.IntN(total)
+	}
+	for _, v := range rec.Variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	// сюда не попасть: n всегда меньше суммы весов
+	return rec.Variants[len(rec.Variants)-1]
+}
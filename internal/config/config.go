@@ -1,37 +1,123 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"github.com/caarlos0/env/v6"
+	"net/http"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	ServerAddress   string `env:"SERVER_ADDRESS"`
+	ServerAddress string `env:"SERVER_ADDRESS"`
+	// GRPCAddress - адрес gRPC-сервера, пустая строка его отключает
+	GRPCAddress     string `env:"GRPC_ADDRESS"`
 	BaseURL         string `env:"BASE_URL"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
 	Environment     string `env:"ENVIRONMENT"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
+	SecretKey       string `env:"SECRET_KEY"`
+	// ReservedAliases не дают пользовательским алиасам перекрыть собственные роуты сервиса
+	ReservedAliases []string      `env:"RESERVED_ALIASES" envSeparator:","`
+	ReaperInterval  time.Duration `env:"REAPER_INTERVAL"`
+	// AnonymousTTL - срок жизни ссылок, созданных без авторизационной куки, 0 - бессрочно
+	AnonymousTTL time.Duration `env:"ANONYMOUS_TTL"`
+	// TrustedSubnet - CIDR, которому открыт /api/internal/*, пустая строка закрывает доступ всем
+	TrustedSubnet string `env:"TRUSTED_SUBNET"`
+	// TrustedProxies - подсети обратных прокси, только от них принимаются X-Real-IP и X-Forwarded-For
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+	// RedirectCode - код редиректа для ссылок, которым он не задан явно
+	RedirectCode int `env:"REDIRECT_CODE"`
+	// RedirectCacheTTL - сколько браузер может кешировать постоянные редиректы 301 и 308, по умолчанию 0 - не может.
+	// Закешированные переходы не попадают в статистику, а правки ссылки видны не сразу
+	RedirectCacheTTL time.Duration `env:"REDIRECT_CACHE_TTL"`
+	// ValidateRequests включает проверку тел запросов к /api/* по OpenAPI-спецификации
+	ValidateRequests bool `env:"VALIDATE_REQUESTS"`
+	// URLPolicyFile - json с политикой сокращаемых адресов, без него действует политика по умолчанию
+	URLPolicyFile string `env:"URL_POLICY_FILE"`
+	// BlocklistFile - локальный блок-лист вредоносных адресов, пустая строка отключает проверку
+	BlocklistFile string `env:"BLOCKLIST_FILE"`
+	// BlocklistReloadInterval - как часто проверять, не изменился ли блок-лист, 0 - не перечитывать
+	BlocklistReloadInterval time.Duration `env:"BLOCKLIST_RELOAD_INTERVAL"`
+	// CheckOnRedirect проверяет адрес и на переходе: ссылки, попавшие в блок-лист позже, отключаются
+	CheckOnRedirect bool `env:"CHECK_ON_REDIRECT"`
+	// CanonicalizeURLs приводит url к одному виду перед хешированием, чтобы одинаковые адреса не дублировались.
+	// Сохраняется и отдается при переходе уже приведенный url, поэтому по умолчанию выключено: сайтам,
+	// которым важен порядок параметров или регистр хоста, такая правка может сломать ссылку
+	CanonicalizeURLs bool `env:"CANONICALIZE_URLS"`
+	// StripTrackingParams убирает из url метки utm_* и подобные, работает вместе с CanonicalizeURLs
+	StripTrackingParams bool `env:"STRIP_TRACKING_PARAMS"`
+	// StripFragment убирает из url фрагмент после "#", работает вместе с CanonicalizeURLs
+	StripFragment bool `env:"STRIP_FRAGMENT"`
 }
 
 func New() (*Config, error) {
 	cfg := &Config{}
 
 	defaultAddress := "localhost:8080"
+	defaultGRPCAddress := "localhost:3200"
 	defaultBaseURL := "http://localhost:8080"
 	defaultFile := ""
 	defaultEnvironment := "dev"
 	defaultDatabaseDSN := ""
+	defaultSecretKey := ""
+	cfg.ReservedAliases = []string{"ping", "api", "static", "admin", "health"}
+	defaultReaperInterval := time.Minute
+	defaultAnonymousTTL := time.Duration(0)
+	defaultTrustedSubnet := ""
+	defaultRedirectCode := http.StatusTemporaryRedirect
 
 	flag.StringVar(&cfg.ServerAddress, "a", defaultAddress, "Address to run the server")
+	flag.StringVar(&cfg.GRPCAddress, "g", defaultGRPCAddress, "Address to run the gRPC server, empty disables it")
 	flag.StringVar(&cfg.BaseURL, "b", defaultBaseURL, "Base URL for shortened links")
 	flag.StringVar(&cfg.FileStoragePath, "f", defaultFile, "Path to file for persistent storage")
 	flag.StringVar(&cfg.Environment, "e", defaultEnvironment, "Environment")
 	flag.StringVar(&cfg.DatabaseDSN, "d", defaultDatabaseDSN, "Database url")
+	flag.StringVar(&cfg.SecretKey, "k", defaultSecretKey, "Secret key for signing auth cookies")
+	flag.Func("r", "Comma separated list of reserved aliases", func(s string) error {
+		cfg.ReservedAliases = strings.Split(s, ",")
+		return nil
+	})
+	flag.DurationVar(&cfg.ReaperInterval, "reaper-interval", defaultReaperInterval, "Interval between expired urls cleanups, 0 disables cleanup")
+	flag.StringVar(&cfg.TrustedSubnet, "t", defaultTrustedSubnet, "Trusted subnet in CIDR notation for internal endpoints")
+	flag.Func("trusted-proxies", "Comma separated CIDRs of reverse proxies allowed to set X-Real-IP and X-Forwarded-For", func(s string) error {
+		cfg.TrustedProxies = strings.Split(s, ",")
+		return nil
+	})
+	flag.DurationVar(&cfg.AnonymousTTL, "anonymous-ttl", defaultAnonymousTTL, "Default TTL for urls of anonymous users, 0 means no expiration")
+	flag.IntVar(&cfg.RedirectCode, "redirect-code", defaultRedirectCode, "Default redirect status code: 301, 302, 307 or 308")
+	flag.DurationVar(&cfg.RedirectCacheTTL, "redirect-cache-ttl", 0, "How long browsers may cache 301 and 308 redirects, 0 disables caching")
+	flag.BoolVar(&cfg.ValidateRequests, "validate-requests", false, "Validate /api/* request bodies against the OpenAPI spec")
+	flag.StringVar(&cfg.URLPolicyFile, "url-policy", "", "Path to JSON file with allowed schemes, domain lists and other limits for shortened urls")
+	flag.StringVar(&cfg.BlocklistFile, "blocklist", "", "Path to malicious urls blocklist, empty disables the check")
+	flag.DurationVar(&cfg.BlocklistReloadInterval, "blocklist-reload", 10*time.Second, "Interval between blocklist change checks, 0 disables reloading")
+	flag.BoolVar(&cfg.CheckOnRedirect, "check-on-redirect", true, "Check destinations against the blocklist on every redirect too")
+	flag.BoolVar(&cfg.CanonicalizeURLs, "canonicalize-urls", false, "Normalize urls before hashing so equivalent urls share one short id, the normalized url is what gets stored")
+	flag.BoolVar(&cfg.StripTrackingParams, "strip-tracking-params", false, "Remove utm_* and other tracking query params from normalized urls")
+	flag.BoolVar(&cfg.StripFragment, "strip-fragment", false, "Remove #fragment from normalized urls")
 	flag.Parse()
 
 	if err := env.Parse(cfg); err != nil {
 		return nil, err
 	}
 
+	switch cfg.RedirectCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("unsupported redirect code %d", cfg.RedirectCode)
+	}
+
+	// без заданного ключа куки не переживут перезапуск сервера
+	if cfg.SecretKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		cfg.SecretKey = hex.EncodeToString(key)
+	}
+
 	return cfg, nil
 }
@@ -0,0 +1,2 @@
+ALTER TABLE url ADD COLUMN IF NOT EXISTS user_id VARCHAR;
+CREATE INDEX IF NOT EXISTS url_user_id_idx ON url (user_id);
@@ -0,0 +1 @@
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_short_url_key;
@@ -0,0 +1 @@
+ALTER TABLE url ADD CONSTRAINT url_short_url_key UNIQUE (short_url);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS url_expires_at_idx;
+ALTER TABLE url DROP COLUMN IF EXISTS expires_at;
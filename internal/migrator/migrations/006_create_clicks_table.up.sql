@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer VARCHAR NOT NULL DEFAULT '',
+    referrer_domain VARCHAR NOT NULL DEFAULT '',
+    user_agent VARCHAR NOT NULL DEFAULT '',
+    browser VARCHAR NOT NULL DEFAULT '',
+    client_ip VARCHAR NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url);
@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN IF NOT EXISTS redirect_code SMALLINT NOT NULL DEFAULT 0;
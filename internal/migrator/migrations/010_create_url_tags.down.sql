@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS url_tags;
+ALTER TABLE url DROP COLUMN IF EXISTS title;
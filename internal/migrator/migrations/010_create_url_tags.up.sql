@@ -0,0 +1,7 @@
+ALTER TABLE url ADD COLUMN IF NOT EXISTS title VARCHAR NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS url_tags (
+    short_url VARCHAR NOT NULL REFERENCES url (short_url) ON DELETE CASCADE,
+    tag VARCHAR NOT NULL,
+    PRIMARY KEY (short_url, tag)
+);
+CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag);
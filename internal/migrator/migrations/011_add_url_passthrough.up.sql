@@ -0,0 +1 @@
+ALTER TABLE url ADD COLUMN IF NOT EXISTS passthrough VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE clicks DROP COLUMN IF EXISTS variant;
+ALTER TABLE url DROP COLUMN IF EXISTS sticky_variants;
+ALTER TABLE url DROP COLUMN IF EXISTS variants;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS url_live_long_url_key;
+ALTER TABLE url ADD CONSTRAINT url_long_url_key UNIQUE (long_url);
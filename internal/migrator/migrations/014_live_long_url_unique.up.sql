@@ -0,0 +1,2 @@
+ALTER TABLE url DROP CONSTRAINT IF EXISTS url_long_url_key;
+CREATE UNIQUE INDEX IF NOT EXISTS url_live_long_url_key ON url (long_url) WHERE NOT is_deleted;
@@ -0,0 +1,123 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	qrreader "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumDataCodewords(t *testing.T) {
+	tests := []struct {
+		version int
+		level   Level
+		want    int
+	}{
+		{1, Low, 19},
+		{1, Medium, 16},
+		{1, Quartile, 13},
+		{1, High, 9},
+		{10, Medium, 216},
+		{40, Low, 2956},
+		{40, Medium, 2334},
+		{40, Quartile, 1666},
+		{40, High, 1276},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, numDataCodewords(tt.version, tt.level), "version %d level %d", tt.version, tt.level)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		level   Level
+		size    int
+		wantErr error
+	}{
+		{name: "Short url fits version 2", data: "http://localhost:8080/abc", level: Medium, size: 25},
+		{name: "Higher level needs bigger version", data: "http://localhost:8080/abc", level: High, size: 33},
+		{name: "Too long", data: strings.Repeat("a", 3000), level: Low, wantErr: ErrDataTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode([]byte(tt.data), tt.level)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.size, code.Size)
+			// поисковые узоры: темная рамка 7x7 со светлым кольцом и темным центром
+			for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+				x, y := corner[0], corner[1]
+				assert.True(t, code.Dark(x, y))
+				assert.False(t, code.Dark(x+1, y+1))
+				assert.True(t, code.Dark(x+3, y+3))
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("http://localhost:8080/abc"), Medium)
+	require.NoError(t, err)
+
+	data, err := code.PNG(200)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 200, img.Bounds().Dx())
+
+	_, err = code.PNG(10)
+	assert.Error(t, err)
+
+	// модули одного размера, и картинка читается обратно декодером при любом размере
+	reader := qrreader.NewQRCodeReader()
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_PURE_BARCODE: true}
+	for _, size := range []int{33, 100, 200, 256, 1000} {
+		data, err := code.PNG(size)
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		assertWholeModules(t, img, size/(code.Size+2*quietZone))
+		bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+		require.NoError(t, err)
+		result, err := reader.Decode(bmp, hints)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, "http://localhost:8080/abc", result.GetText(), "size %d", size)
+	}
+
+	svg := string(code.SVG(200))
+	assert.Contains(t, svg, `width="200"`)
+	assert.Contains(t, svg, `viewBox="0 0 33 33"`)
+}
+
+// assertWholeModules проверяет, что каждая темная полоса в строках картинки кратна размеру модуля
+func assertWholeModules(t *testing.T, img image.Image, scale int) {
+	t.Helper()
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		run := 0
+		for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+			if x < bounds.Max.X {
+				if r, _, _, _ := img.At(x, y).RGBA(); r == 0 {
+					run++
+					continue
+				}
+			}
+			if run%scale != 0 {
+				t.Fatalf("row %d: dark run of %d pixels is not a multiple of module size %d", y, run, scale)
+			}
+			run = 0
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// quietZone - обязательная светлая рамка вокруг кода, в модулях
+const quietZone = 4
+
+// PNG рисует код вместе с рамкой в квадратную картинку со стороной size пикселей.
+// Модуль занимает целое число пикселей, чтобы все модули были одного размера: дробный
+// масштаб не читают строгие декодеры. Остаток стороны уходит в светлые поля по краям
+func (c *Code) PNG(size int) ([]byte, error) {
+	total := c.Size + 2*quietZone
+	if size < total {
+		return nil, fmt.Errorf("image size %d is less than qr code size %d", size, total)
+	}
+
+	scale := size / total
+	offset := (size-scale*total)/2 + quietZone*scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for py := offset + y*scale; py < offset+(y+1)*scale; py++ {
+				for px := offset + x*scale; px < offset+(x+1)*scale; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG рисует код векторно: одна ячейка viewBox - один модуль
+func (c *Code) SVG(size int) []byte {
+	total := c.Size + 2*quietZone
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		size, size, total, total)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#FFFFFF"/>`+"\n")
+	buf.WriteString(`<path fill="#000000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				fmt.Fprintf(&buf, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	buf.WriteString("\"/>\n</svg>\n")
+	return buf.Bytes()
+}